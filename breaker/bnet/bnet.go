@@ -0,0 +1,107 @@
+// Package bnet protects byte-stream connections with a circuit-breaker, by passing
+// every Read and Write on a net.Conn through a breaker.Breaker.
+package bnet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/etherlabsio/resiliency/breaker"
+)
+
+// WrapConn returns a net.Conn that makes each Read and Write on c through b. While
+// the breaker is open they return its error (breaker.ErrBreakerOpen, unless b was
+// built with breaker.WithOpenErrorFormatter) without touching c, so callers can tell
+// a short-circuited call from a failed one.
+//
+// I/O errors count as failures, including timeouts and connection resets, with two
+// exceptions: io.EOF, which is how a peer cleanly closes the connection, and a
+// timeout while the caller has set a deadline (with SetDeadline, SetReadDeadline or
+// SetWriteDeadline) in that direction, since that is the caller giving up rather
+// than the connection failing. Both of those are ignored, so that they neither trip
+// the breaker nor close it while half-open. Every other error is passed to the
+// breaker's result classifier, so DefaultClassifier counts a timeout with no
+// deadline set as a Timeout (see breaker.WithTimeoutThreshold). Close, and the
+// address and deadline methods, go straight to c.
+func WrapConn(c net.Conn, b *breaker.Breaker) net.Conn {
+	return &conn{Conn: c, breaker: b}
+}
+
+type conn struct {
+	net.Conn
+	breaker *breaker.Breaker
+
+	lock                        sync.Mutex
+	readDeadline, writeDeadline time.Time
+}
+
+func (c *conn) Read(p []byte) (n int, err error) {
+	result := c.breaker.Run(func() error {
+		n, err = c.Conn.Read(p)
+		return failure(err, c.deadline(true))
+	})
+	if result != nil && err == nil {
+		// the breaker rejected the call
+		return 0, result
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (n int, err error) {
+	result := c.breaker.Run(func() error {
+		n, err = c.Conn.Write(p)
+		return failure(err, c.deadline(false))
+	})
+	if result != nil && err == nil {
+		return 0, result
+	}
+	return n, err
+}
+
+// failure returns the result to give the breaker for err: err itself, unless it
+// is one that should not affect the breaker.
+func failure(err error, deadline time.Time) error {
+	if err == io.EOF {
+		return breaker.IgnoreResult(err)
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && !deadline.IsZero() {
+		return breaker.IgnoreResult(err)
+	}
+	return err
+}
+
+func (c *conn) deadline(read bool) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if read {
+		return c.readDeadline
+	}
+	return c.writeDeadline
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.lock.Unlock()
+
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.readDeadline = t
+	c.lock.Unlock()
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.writeDeadline = t
+	c.lock.Unlock()
+
+	return c.Conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,122 @@
+package bnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/resiliency/breaker"
+)
+
+// failingConn is a net.Conn whose Read and Write always fail with err.
+type failingConn struct {
+	net.Conn
+	err error
+}
+
+func (c failingConn) Read(p []byte) (int, error)  { return 0, c.err }
+func (c failingConn) Write(p []byte) (int, error) { return 0, c.err }
+
+func TestWrapConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	b := breaker.New(1, 1, 1*time.Hour)
+	wrapped := WrapConn(client, b)
+
+	go func() {
+		buf := make([]byte, 5)
+		_, _ = io.ReadFull(server, buf)
+		_, _ = server.Write(buf)
+		server.Close()
+	}()
+
+	if _, err := wrapped.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(wrapped, buf); err != nil || string(buf) != "hello" {
+		t.Fatal(string(buf), err)
+	}
+
+	// a clean close is not a failure
+	if _, err := wrapped.Read(buf); err != io.EOF {
+		t.Error(err)
+	}
+	if b.State() != breaker.Closed {
+		t.Error(b.State())
+	}
+}
+
+func TestWrapConnCallerDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	b := breaker.New(1, 1, 1*time.Hour)
+	wrapped := WrapConn(client, b)
+
+	if err := wrapped.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := wrapped.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatal(err)
+	}
+	if b.State() != breaker.Closed {
+		t.Error(b.State())
+	}
+}
+
+func TestWrapConnFailures(t *testing.T) {
+	b := breaker.New(2, 1, 1*time.Hour)
+	wrapped := WrapConn(failingConn{err: syscall.ECONNRESET}, b)
+
+	for i := 0; i < 2; i++ {
+		if _, err := wrapped.Write([]byte("x")); err != syscall.ECONNRESET {
+			t.Error(err)
+		}
+	}
+	if b.State() != breaker.Open {
+		t.Fatal(b.State())
+	}
+
+	// short-circuited calls get the breaker's error
+	if n, err := wrapped.Read(make([]byte, 1)); n != 0 || !errors.Is(err, breaker.ErrBreakerOpen) {
+		t.Error(n, err)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestWrapConnTimeoutWithoutDeadline(t *testing.T) {
+	b := breaker.New(1, 1, 1*time.Hour)
+	wrapped := WrapConn(failingConn{err: timeoutError{}}, b)
+
+	if _, err := wrapped.Read(make([]byte, 1)); err != (timeoutError{}) {
+		t.Error(err)
+	}
+	if b.State() != breaker.Open {
+		t.Error(b.State())
+	}
+}
+
+func TestWrapConnEOFWhileHalfOpen(t *testing.T) {
+	b := breaker.New(1, 1, 1*time.Hour)
+	wrapped := WrapConn(failingConn{err: io.EOF}, b)
+
+	// a clean close says nothing about whether the peer has recovered
+	b.Trip()
+	b.HalfOpen()
+	if _, err := wrapped.Read(make([]byte, 1)); err != io.EOF {
+		t.Error(err)
+	}
+	if stats := b.Stats(); stats.State != breaker.HalfOpen || stats.Ignored != 1 {
+		t.Errorf("%+v", stats)
+	}
+}
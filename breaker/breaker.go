@@ -2,7 +2,11 @@
 package breaker
 
 import (
+	"context"
 	"errors"
+	"math"
+	"math/rand"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,47 +16,303 @@ import (
 // because the breaker is currently open.
 var ErrBreakerOpen = errors.New("circuit breaker is open")
 
+// ErrProbeLimitReached is the error returned from Run() when the function is not
+// executed because the breaker is half-open and already has the maximum number of
+// probes in flight (see WithHalfOpenProbes). The dependency may well be recovering,
+// so callers may want to retry sooner than they would for ErrBreakerOpen. It is not
+// equal to ErrBreakerOpen, but errors.Is(ErrProbeLimitReached, ErrBreakerOpen) is true.
+var ErrProbeLimitReached error = probeLimitError{}
+
+// ErrNilFunc is the error returned from Run() and the other ways of making a call when
+// the function passed is nil. The breaker is not affected.
+var ErrNilFunc = errors.New("circuit breaker given a nil function")
+
+type probeLimitError struct{}
+
+func (probeLimitError) Error() string {
+	return "circuit breaker is half-open and at its probe limit"
+}
+
+func (probeLimitError) Is(target error) bool {
+	return target == ErrBreakerOpen
+}
+
+// The configuration used by a zero-value Breaker, which applies them lazily the
+// first time it is used.
+const (
+	DefaultErrorThreshold   = 5
+	DefaultSuccessThreshold = 1
+	DefaultTimeout          = 30 * time.Second
+)
+
 const (
 	closed uint32 = iota
 	open
 	halfOpen
+	pending
+	degraded
 )
 
-// Breaker implements the circuit-breaker resiliency pattern
+// Breaker implements the circuit-breaker resiliency pattern. The zero value is
+// ready to use (for example when embedded in another struct) and behaves as if
+// constructed with New(DefaultErrorThreshold, DefaultSuccessThreshold, DefaultTimeout).
 type Breaker struct {
+	initOnce sync.Once
+	counters *counters // allocated by lazyInit
+
 	errorThreshold, successThreshold int
 	timeout                          time.Duration
+	window                           time.Duration // for errors; zero means the timeout
+
+	name       string
+	manual     bool
+	timeoutAt  int
+	gated      bool
+	shadow     uint32 // atomic
+	probing    bool   // healthy while half-open or pending
+	openErr    func(name string, retryAt time.Time) error
+	maxProbes  int
+	onHalfOpen func()
+	onClose    func()
+	classify   func(error) Outcome
+	weigh      func(error) float64
+	onPanic    func(recovered interface{}, stack []byte)
+	clock      Clock
+	budget     *errorBudget
+	thresholdF func() int
+
+	degradeAt    int
+	shedFraction float64
+	rand         *rand.Rand // guarded by lock
+
+	lock         sync.Mutex
+	state, epoch uint32
+	errors       int
+	timeouts     int // of the errors, how many were timeouts
+	successes    float64
+	probes       int
+	outstanding  int // probes granted by AllowProbe and not yet marked
+	probeReady   chan struct{}
+	shed         int
+	shadowShed   int
+	probeStats   struct {
+		attempted, succeeded, reopened int
+	}
+	lastError  time.Time
+	transition struct {
+		cause Cause
+		at    time.Time
+	}
+	openedAt    time.Time
+	recovery    Timer
+	recoveryGen uint64
+
+	fixedTimeout time.Duration
+	grace        time.Duration
+	adaptive     *adaptiveTimeout
+	minDeadline  time.Duration
+
+	latency struct {
+		sync.Mutex
+		success, failure, canceled, timeout LatencyStats
+		recent                              latencySamples // of successes only
+	}
 
-	lock              sync.Mutex
-	state             uint32
-	errors, successes int
-	lastError         time.Time
+	subs    subscribers
+	history eventRing
+}
+
+// Option configures optional behaviour of a Breaker constructed with NewWithOptions.
+type Option func(*Breaker)
+
+// WithManualRecovery stops the breaker from ever half-opening by itself. Once open,
+// it stays open until HalfOpen or Reset is called, for dependencies where retrying
+// before a human has verified the fix could cause more damage.
+func WithManualRecovery() Option {
+	return func(b *Breaker) {
+		b.manual = true
+	}
+}
+
+// WithMeasurementWindow sets how long a closed breaker remembers errors: it opens on
+// "errorThreshold" errors without an error-free period of at least "window" between
+// them. Without this option the window is the breaker's timeout, which is then also
+// how long it stays open, but the two often want to differ: a short recovery delay
+// for a quick retry with a longer window to catch errors that are spread out. A
+// window of zero or less means the timeout.
+func WithMeasurementWindow(window time.Duration) Option {
+	return func(b *Breaker) {
+		b.window = window
+	}
+}
+
+// WithHalfOpenHealthy makes Healthy report a half-open (or pending) breaker as
+// healthy, rather than only one that is closed or degraded.
+func WithHalfOpenHealthy() Option {
+	return func(b *Breaker) {
+		b.probing = true
+	}
+}
+
+// WithStartupGate makes the breaker start out pending rather than closed, both when
+// constructed and after Reset. A pending breaker admits every call, like a closed one,
+// but must see "successThreshold" successes to close; if it sees "errorThreshold"
+// failures first (however far apart) it opens immediately instead. This proves the
+// dependency healthy before the breaker is trusted, rather than assuming it is. A
+// breaker that closes after recovering from open does not pass through the gate.
+func WithStartupGate() Option {
+	return func(b *Breaker) {
+		b.gated = true
+	}
+}
+
+// WithName gives the breaker a name, used when describing it.
+func WithName(name string) Option {
+	return func(b *Breaker) {
+		b.name = name
+	}
+}
+
+// WithOpenErrorFormatter makes the breaker call the given function to produce the
+// error returned when a call is rejected because the breaker is open, in place of
+// ErrBreakerOpen. The function is passed the breaker's name (see WithName) and the
+// time at which the breaker is due to half-open (the zero Time if it is waiting for
+// manual recovery), and may for example embed a retry-after hint or wrap a domain
+// error. To keep callers' errors.Is(err, ErrBreakerOpen) checks working, wrap
+// ErrBreakerOpen in the returned error (e.g. with fmt.Errorf and %w).
+// ErrProbeLimitReached is not affected by this option.
+func WithOpenErrorFormatter(format func(name string, retryAt time.Time) error) Option {
+	return func(b *Breaker) {
+		b.openErr = format
+	}
+}
+
+// WithHalfOpenProbes limits the number of calls the breaker lets through at once
+// while it is half-open. Calls beyond the limit are rejected with ErrProbeLimitReached.
+// A limit of zero (the default) admits every call while half-open.
+func WithHalfOpenProbes(n int) Option {
+	return func(b *Breaker) {
+		b.maxProbes = n
+	}
+}
+
+// WithClock makes the breaker use the given Clock instead of the time package. A nil
+// Clock means the time package.
+func WithClock(c Clock) Option {
+	if c == nil {
+		// a breaker without a clock would be taken for a zero Breaker
+		c = systemClock{}
+	}
+	return func(b *Breaker) {
+		b.clock = c
+	}
+}
+
+// WithFailureThresholdFunc makes the breaker call the given function each time it
+// sees an error while closed, using the result in place of "errorThreshold" to decide
+// whether to open. This lets the threshold follow live signals such as traffic or an
+// SLO budget. If the function returns zero or less, "errorThreshold" is used instead.
+// The function is called with the breaker's lock held, so it must be fast and must
+// not call back into the breaker.
+func WithFailureThresholdFunc(threshold func() int) Option {
+	return func(b *Breaker) {
+		b.thresholdF = threshold
+	}
+}
+
+// WithSuccessWeight gives partial credit to successes while the breaker is half-open,
+// or pending (see WithStartupGate). The function is called with the result of each
+// call classified as a Success in those states and returns how much that success
+// counts towards "successThreshold", between 0 and 1 (values outside this range are
+// clamped, and NaN counts as 0). The breaker closes once the accumulated weight
+// reaches the threshold. Without this option every success has a weight of 1.
+func WithSuccessWeight(weigh func(error) float64) Option {
+	return func(b *Breaker) {
+		b.weigh = weigh
+	}
+}
+
+// WithPanicCapture registers a function to be called when a function run by the
+// breaker panics, with the recovered value and the stack trace of the panic. The
+// breaker re-panics with the same value afterwards, but that loses the original
+// location of the panic, so this is the place to log it. The function is called
+// from within the deferred recovery, on the goroutine that panicked.
+func WithPanicCapture(capture func(recovered interface{}, stack []byte)) Option {
+	return func(b *Breaker) {
+		b.onPanic = capture
+	}
+}
+
+// WithOnHalfOpen registers a function to be called each time the breaker moves from
+// open to half-open, before any probe traffic is admitted. It is called without the
+// breaker's lock held, so it may safely call back into the breaker; it is a good place
+// to warm caches or re-establish connections ahead of the first probes.
+func WithOnHalfOpen(fn func()) Option {
+	return func(b *Breaker) {
+		b.onHalfOpen = fn
+	}
+}
+
+// WithOnClose registers a function to be called each time the breaker closes after
+// being open or half-open: when its probes succeed, or when Reset is called on an
+// open or half-open breaker. It is not called for a Reset of a breaker that was
+// already closed, so it marks the dependency recovering (or an operator deciding it
+// has), which makes it a good place to resolve an alert raised when the breaker
+// opened. Like the function given to WithOnHalfOpen, it is called without the
+// breaker's lock held and exactly once per close.
+func WithOnClose(fn func()) Option {
+	return func(b *Breaker) {
+		b.onClose = fn
+	}
 }
 
 // New constructs a new circuit-breaker that starts closed.
 // From closed, the breaker opens if "errorThreshold" errors are seen
-// without an error-free period of at least "timeout". From open, the
+// without an error-free period of at least "timeout" (but see
+// WithMeasurementWindow). From open, the
 // breaker half-closes after "timeout". From half-open, the breaker closes
 // after "successThreshold" consecutive successes, or opens on a single error.
+// A timeout of zero or less would give no protection at all (the breaker would
+// half-open again immediately), so DefaultTimeout is used instead.
 func New(errorThreshold, successThreshold int, timeout time.Duration) *Breaker {
-	return &Breaker{
+	return NewWithOptions(errorThreshold, successThreshold, timeout)
+}
+
+// NewWithOptions constructs a new circuit-breaker exactly like New, additionally
+// applying the given options in order.
+func NewWithOptions(errorThreshold, successThreshold int, timeout time.Duration, opts ...Option) *Breaker {
+	b := &Breaker{
 		errorThreshold:   errorThreshold,
 		successThreshold: successThreshold,
-		timeout:          timeout,
+		timeout:          validTimeout(timeout),
+		classify:         DefaultClassifier,
+		clock:            systemClock{},
 	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.gated {
+		b.state = pending
+	}
+
+	return b
 }
 
 // Run will either return ErrBreakerOpen immediately if the circuit-breaker is
 // already open, or it will run the given function and pass along its return
 // value. It is safe to call Run concurrently on the same Breaker.
 func (b *Breaker) Run(work func() error) error {
-	state := atomic.LoadUint32(&b.state)
+	if work == nil {
+		return ErrNilFunc
+	}
 
-	if state == open {
-		return ErrBreakerOpen
+	adm, err := b.admit()
+	if err != nil {
+		return err
 	}
 
-	return b.doWork(state, work)
+	return b.doWork(adm, work)
 }
 
 // Go will either return ErrBreakerOpen immediately if the circuit-breaker is
@@ -61,38 +321,424 @@ func (b *Breaker) Run(work func() error) error {
 // the return value of the function. It is safe to call Go concurrently on the
 // same Breaker.
 func (b *Breaker) Go(work func() error) error {
-	state := atomic.LoadUint32(&b.state)
+	if work == nil {
+		return ErrNilFunc
+	}
 
-	if state == open {
-		return ErrBreakerOpen
+	adm, err := b.admit()
+	if err != nil {
+		return err
 	}
 
 	// errcheck complains about ignoring the error return value, but
 	// that's on purpose; if you want an error from a goroutine you have to
 	// get it over a channel or something
-	go b.doWork(state, work)
+	go b.doWork(adm, work)
 
 	return nil
 }
 
-func (b *Breaker) doWork(state uint32, work func() error) error {
+// RunContext is like Run, except that it passes ctx through to the function and
+// records how long the function took to return in the breaker's Stats, broken down
+// by outcome. An error returned after ctx's deadline has passed is a timeout and
+// always counts as a failure; an error returned after ctx was cancelled is a
+// cancellation and never counts against the breaker, since it was the caller that
+// gave up. Any other result is classified as it would be by Run. If the breaker was
+// built with WithCallTimeout or WithAdaptiveTimeout then the context passed to the
+// function carries that timeout too; the function must honour it for it to help.
+// WithCancellationGrace lets the function finish after ctx is done instead.
+//
+// If the call is rejected (because the breaker is open, say) and ctx is already
+// done, ctx.Err() is returned in place of the breaker's error: the caller had given
+// up anyway, and this lets it tell its own cancellation or deadline apart from the
+// breaker's. While ctx is live a rejection returns the breaker's error as usual.
+func (b *Breaker) RunContext(ctx context.Context, work func(context.Context) error) error {
+	if work == nil {
+		return ErrNilFunc
+	}
+
+	adm, err := b.admitContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return b.doWorkContext(ctx, adm, work)
+}
+
+// RunContextExecuted is like RunContext, but additionally reports whether the function
+// was actually executed. This distinguishes a short-circuit from a failure of the
+// function without inspecting the error, which is reliable even if the function itself
+// returns ErrBreakerOpen (for example from a nested breaker).
+func (b *Breaker) RunContextExecuted(ctx context.Context, work func(context.Context) error) (bool, error) {
+	if work == nil {
+		return false, ErrNilFunc
+	}
+
+	adm, err := b.admitContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return true, b.doWorkContext(ctx, adm, work)
+}
+
+// admitContext admits a call made with a context, applying the precedence given
+// in RunContext's documentation to any rejection.
+func (b *Breaker) admitContext(ctx context.Context) (admission, error) {
+	var err error
+	if !b.shadowed() {
+		err = b.checkDeadline(ctx)
+	}
+	var adm admission
+	if err == nil {
+		adm, err = b.admit()
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return adm, ctxErr
+		}
+	}
+	return adm, err
+}
+
+func (b *Breaker) doWorkContext(ctx context.Context, adm admission, work func(context.Context) error) error {
+	if timeout, ok := b.callTimeout(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	b.enter()
+	defer b.exit()
+
+	start := b.clock.Now()
+	var result error
 	var panicValue interface{}
+	finished := false // within the grace period, see WithCancellationGrace
+	if b.grace > 0 {
+		var abandoned bool
+		result, panicValue, abandoned = b.runWithGrace(ctx, work)
+		finished = !abandoned
+	} else {
+		result, panicValue = protect(b.onPanic, func() error {
+			return work(ctx)
+		})
+	}
+	elapsed := b.clock.Now().Sub(start)
 
-	result := func() error {
-		defer func() {
-			panicValue = recover()
-		}()
-		return work()
-	}()
+	ctxErr := ctx.Err()
+	if finished {
+		// even if ctx is done, the result speaks for itself
+		ctxErr = nil
+	}
 
-	if result == nil && panicValue == nil && state == closed {
-		// short-circuit the normal, success path without contending
-		// on the lock
-		return nil
+	outcome := Failure
+	latency := &b.latency.failure
+	if panicValue == nil {
+		switch {
+		case result != nil && ctxErr == context.DeadlineExceeded:
+			outcome = Timeout
+			latency = &b.latency.timeout
+		case result != nil && ctxErr == context.Canceled:
+			outcome = Ignore
+			latency = &b.latency.canceled
+		default:
+			outcome = b.classifyResult(result)
+			switch outcome {
+			case Success:
+				latency = &b.latency.success
+			case Timeout:
+				latency = &b.latency.timeout
+			case Ignore:
+				latency = nil
+			}
+		}
 	}
 
-	// oh well, I guess we have to contend on the lock
-	b.processResult(result, panicValue)
+	if latency != nil {
+		b.latency.Lock()
+		latency.add(elapsed)
+		if outcome == Success {
+			b.latency.recent.add(elapsed)
+		}
+		b.latency.Unlock()
+	}
+
+	b.finish(adm, outcome, result)
+
+	if panicValue != nil {
+		panic(panicValue)
+	}
+
+	return result
+}
+
+// AllowProbe reports whether the breaker wants a probe right now, for callers that
+// check the health of the dependency themselves rather than through Run. It returns
+// true only when the breaker is half-open and has a probe slot free (see
+// WithHalfOpenProbes), in which case the slot is reserved. Each true result must be
+// followed by exactly one call to MarkProbeResult with the outcome of the probe.
+func (b *Breaker) AllowProbe() bool {
+	b.lazyInit()
+
+	b.lock.Lock()
+	halfOpened := b.catchUp()
+	allowed := b.state == halfOpen && (b.maxProbes <= 0 || b.probes < b.maxProbes)
+	if allowed {
+		b.probes++
+		b.outstanding++
+		b.probeStats.attempted++
+	}
+	b.lock.Unlock()
+
+	if halfOpened {
+		b.halfOpened()
+	}
+	return allowed
+}
+
+// ProbeReady returns a channel that is closed once the breaker is ready to admit a
+// probe: when it is half-open with a probe slot free, or when it has closed (through
+// recovery or Reset), at which point any call is admitted. If that is already the
+// case the channel is closed on return. This lets event-driven callers park rather
+// than poll AllowProbe. Every waiter is woken at once, so being woken is only a hint:
+// AllowProbe (or Run) may still fail if another caller takes the slot first, in which
+// case call ProbeReady again to keep waiting.
+func (b *Breaker) ProbeReady() <-chan struct{} {
+	b.lazyInit()
+
+	b.lock.Lock()
+	halfOpened := b.catchUp()
+	ready := readyNow
+	if !b.probeAvailable() {
+		if b.probeReady == nil {
+			b.probeReady = make(chan struct{})
+		}
+		ready = b.probeReady
+	}
+	b.lock.Unlock()
+
+	if halfOpened {
+		b.halfOpened()
+	}
+	return ready
+}
+
+var readyNow = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+func (b *Breaker) probeAvailable() bool {
+	switch b.state {
+	case closed:
+		return true
+	case halfOpen:
+		return b.maxProbes <= 0 || b.probes < b.maxProbes
+	case pending, degraded:
+		return true
+	}
+	return false
+}
+
+func (b *Breaker) notifyProbeReady() {
+	if b.probeReady != nil && b.probeAvailable() {
+		close(b.probeReady)
+		b.probeReady = nil
+	}
+}
+
+// MarkProbeResult feeds the result of a probe granted by AllowProbe back to the
+// breaker, releasing its slot. The result is classified and counts towards closing
+// or re-opening the breaker exactly as if the probe had been made through Run. A
+// result that does not correspond to an outstanding probe (for example because the
+// breaker has changed state since AllowProbe returned) is ignored.
+func (b *Breaker) MarkProbeResult(err error) {
+	b.lazyInit()
+
+	b.lock.Lock()
+	if b.state != halfOpen || b.outstanding == 0 {
+		b.lock.Unlock()
+		return
+	}
+	b.outstanding--
+	adm := admission{state: b.state, epoch: b.epoch, probe: true}
+	b.lock.Unlock()
+
+	b.finish(adm, b.classifyResult(err), err)
+}
+
+// RecordFailure feeds the breaker a failure observed outside of it, such as from a
+// health check or a sidecar, without a call having been made through Run. The error
+// is classified as usual (so it may turn out not to be a failure at all) and then
+// counted exactly as the result of a call would be. RecordSuccess does the same for a
+// success. Both are safe to call concurrently with everything else.
+//
+// While the breaker is half-open a recorded result counts as a probe, towards
+// closing or re-opening it, but does not need or release a probe slot (see
+// WithHalfOpenProbes). While the breaker is open both are ignored, since a call made
+// then would have been rejected.
+func (b *Breaker) RecordFailure(err error) {
+	b.lazyInit() // for the classifier
+
+	b.record(b.classifyResult(err), err)
+}
+
+// RecordSuccess feeds the breaker a success observed outside of it; see RecordFailure.
+func (b *Breaker) RecordSuccess() {
+	b.record(Success, nil)
+}
+
+func (b *Breaker) record(outcome Outcome, result error) {
+	b.lazyInit()
+
+	b.lock.Lock()
+	adm := admission{state: b.state, epoch: b.epoch}
+	switch b.state {
+	case open:
+		b.lock.Unlock()
+		return
+	case halfOpen:
+		b.probeStats.attempted++
+	}
+	b.lock.Unlock()
+
+	b.finish(adm, outcome, result)
+}
+
+func validTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultTimeout
+	}
+	return timeout
+}
+
+// counters are the breaker's atomically updated 64-bit fields. They are allocated
+// separately because 64-bit atomic operations need 64-bit alignment, which on
+// 32-bit platforms only the start of an allocation is guaranteed, and a Breaker may
+// be embedded anywhere in another struct.
+type counters struct {
+	ignored        uint64
+	dueAt          int64 // UnixNano when the pending recovery is due, or zero
+	inFlight, peak int64 // calls running, and the most there have ever been
+}
+
+// lazyInit allocates the breaker's counters, and fills in the configuration of a
+// Breaker that was not built by a constructor, which is recognisable by its lack of
+// a clock.
+func (b *Breaker) lazyInit() {
+	b.initOnce.Do(func() {
+		b.counters = new(counters)
+		if b.clock != nil {
+			return
+		}
+		b.errorThreshold = DefaultErrorThreshold
+		b.successThreshold = DefaultSuccessThreshold
+		b.timeout = DefaultTimeout
+		b.classify = DefaultClassifier
+		b.clock = systemClock{}
+	})
+}
+
+// admission records the circumstances under which a call was let through, so
+// that its result can be attributed correctly once it completes.
+type admission struct {
+	state  uint32
+	epoch  uint32
+	probe  bool // occupies one of the limited half-open probe slots
+	shadow bool // would have been rejected (see SetShadowMode), so does not count
+}
+
+func (b *Breaker) admit() (admission, error) {
+	adm, err := b.tryAdmit()
+	if err != nil {
+		shadow := b.shadowed()
+		b.emitRejected(adm.state, err, shadow)
+		if shadow {
+			return admission{shadow: true}, nil
+		}
+	}
+	return adm, err
+}
+
+func (b *Breaker) tryAdmit() (admission, error) {
+	b.lazyInit()
+
+	// load the epoch first; changeState stores them in the opposite order, so at
+	// worst we pair an old epoch with a new state and the result is discarded
+	adm := admission{epoch: atomic.LoadUint32(&b.epoch)}
+	adm.state = atomic.LoadUint32(&b.state)
+
+	switch adm.state {
+	case open:
+		if b.catchUpRecovery() {
+			return b.tryAdmit()
+		}
+		return adm, b.openError()
+	case halfOpen:
+		adm, err := b.admitHalfOpen()
+		if err == ErrBreakerOpen {
+			err = b.openError()
+		}
+		return adm, err
+	case degraded:
+		return b.admitDegraded()
+	}
+
+	return adm, nil
+}
+
+// openError returns the error for a call rejected because the breaker is open.
+func (b *Breaker) openError() error {
+	if b.openErr == nil {
+		return ErrBreakerOpen
+	}
+
+	b.lock.Lock()
+	retryAt := b.recoversAt()
+	b.lock.Unlock()
+
+	return b.openErr(b.name, retryAt)
+}
+
+// admitHalfOpen admits a call that found the breaker half-open. Unlike in the
+// other states this takes the lock, to account for the probe.
+func (b *Breaker) admitHalfOpen() (admission, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// the state may have moved on since we loaded it without the lock
+	adm := admission{state: b.state, epoch: b.epoch}
+
+	switch b.state {
+	case open:
+		return adm, ErrBreakerOpen
+	case halfOpen:
+		if b.maxProbes > 0 {
+			if b.probes >= b.maxProbes {
+				return adm, ErrProbeLimitReached
+			}
+			b.probes++
+			adm.probe = true
+		}
+		b.probeStats.attempted++
+	}
+
+	return adm, nil
+}
+
+func (b *Breaker) doWork(adm admission, work func() error) error {
+	b.enter()
+	result, panicValue := protect(b.onPanic, work)
+	b.exit()
+
+	outcome := Failure
+	if panicValue == nil {
+		outcome = b.classifyResult(result)
+	}
+
+	b.finish(adm, outcome, result)
 
 	if panicValue != nil {
 		// as close as Go lets us come to a "rethrow" although unfortunately
@@ -103,59 +749,399 @@ func (b *Breaker) doWork(state uint32, work func() error) error {
 	return result
 }
 
-func (b *Breaker) processResult(result error, panicValue interface{}) {
+// enter and exit bracket the execution of an admitted call, tracking how many
+// are running at once.
+func (b *Breaker) enter() {
+	n := atomic.AddInt64(&b.counters.inFlight, 1)
+	for {
+		peak := atomic.LoadInt64(&b.counters.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&b.counters.peak, peak, n) {
+			return
+		}
+	}
+}
+
+func (b *Breaker) exit() {
+	atomic.AddInt64(&b.counters.inFlight, -1)
+}
+
+// protect runs work, recovering any panic so that it can be recorded
+// before being re-raised. If capture is not nil it is passed the panic
+// along with the stack trace, which is only available at this point.
+func protect(capture func(interface{}, []byte), work func() error) (result error, panicValue interface{}) {
+	defer func() {
+		panicValue = recover()
+		if panicValue != nil && capture != nil {
+			capture(panicValue, debug.Stack())
+		}
+	}()
+	return work(), nil
+}
+
+func (b *Breaker) finish(adm admission, outcome Outcome, result error) {
+	if adm.shadow {
+		return
+	}
+	if outcome == Success && adm.state == closed {
+		// short-circuit the normal, success path without contending
+		// on the lock
+		return
+	}
+
+	weight := 1.0
+	if outcome == Success && (adm.state == halfOpen || adm.state == pending) && b.weigh != nil {
+		weight = b.weigh(result)
+		if weight < 0 || math.IsNaN(weight) {
+			weight = 0
+		} else if weight > 1 {
+			weight = 1
+		}
+	}
+
+	// oh well, I guess we have to contend on the lock
+	if b.processResult(adm, outcome, weight) && b.onClose != nil {
+		b.onClose()
+	}
+}
+
+// processResult records the outcome of a call; weight is the credit a success
+// earns towards closing a half-open breaker. It reports whether the call closed
+// the breaker after it had been open.
+func (b *Breaker) processResult(adm admission, outcome Outcome, weight float64) (recovered bool) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	defer b.notifyProbeReady()
+
+	if adm.epoch != b.epoch {
+		// the breaker has changed state since this call was admitted (and any
+		// probe slot it held was released then); its result says nothing about
+		// the current state, so a slow call from before a trip can't close the
+		// breaker again
+		return
+	}
+
+	if adm.probe {
+		b.probes--
+	}
 
-	if result == nil && panicValue == nil {
-		if b.state == halfOpen {
-			b.successes++
-			if b.successes == b.successThreshold {
-				b.closeBreaker()
+	switch outcome {
+	case Success:
+		if b.state == halfOpen || b.state == pending {
+			if b.state == halfOpen {
+				b.probeStats.succeeded++
 			}
-		}
-	} else {
-		if b.errors > 0 {
-			expiry := b.lastError.Add(b.timeout)
-			if time.Now().After(expiry) {
-				b.errors = 0
+			b.successes += weight
+			// allow for rounding, so that e.g. ten successes weighted 0.1 close a
+			// breaker with a threshold of one
+			if b.successes >= float64(b.successThreshold)-1e-9 {
+				recovered = b.closeBreaker(CauseProbeSuccess)
 			}
+		} else if b.state == degraded && b.errorsExpired() {
+			b.shiftTier(closed, CauseErrorsSubsided)
 		}
-
+	case Failure, Timeout:
 		switch b.state {
-		case closed:
+		case closed, degraded:
+			if b.budget != nil {
+				if !b.budget.spend(b.clock.Now()) {
+					b.openBreaker(CauseThreshold)
+				}
+				break
+			}
+			// the error window only means anything while closed or degraded;
+			// in every other state the count is zero (see changeState)
+			if b.errorsExpired() {
+				b.clearErrors()
+			}
 			b.errors++
-			if b.errors == b.errorThreshold {
-				b.openBreaker()
-			} else {
-				b.lastError = time.Now()
+			if outcome == Timeout {
+				b.timeouts++
+			}
+			switch {
+			case b.errors >= b.currentErrorThreshold() || b.timeoutsReached():
+				b.openBreaker(CauseThreshold)
+			case b.degradeAt > 0 && b.errors >= b.degradeAt:
+				b.lastError = b.clock.Now()
+				b.shiftTier(degraded, CauseThreshold)
+			default:
+				// a degraded breaker whose window restarted is healthy again
+				b.lastError = b.clock.Now()
+				b.shiftTier(closed, CauseErrorsSubsided)
 			}
 		case halfOpen:
-			b.openBreaker()
+			// the failed probe is not carried into the open state: nothing is
+			// counted while open, and the next half-open period judges the
+			// dependency only by its own probes
+			b.probeStats.reopened++
+			b.openBreaker(CauseProbeFailure)
+		case pending:
+			// the gate has no error window: failures count until it is passed
+			b.errors++
+			if outcome == Timeout {
+				b.timeouts++
+			}
+			if b.errors >= b.currentErrorThreshold() || b.timeoutsReached() {
+				b.openBreaker(CauseThreshold)
+			}
 		}
 	}
+	return recovered
 }
 
-func (b *Breaker) openBreaker() {
-	b.changeState(open)
-	go b.timer()
+// Reset closes the breaker, clearing its counters and cancelling any pending
+// transition to half-open, regardless of its current state. A breaker built with
+// WithStartupGate is put back in the pending state instead.
+func (b *Breaker) Reset() {
+	b.lazyInit()
+
+	b.lock.Lock()
+
+	// resetting a breaker that is already closed (or pending) is not a transition,
+	// but it still promises a clean slate
+	b.clearErrors()
+	b.successes = 0
+
+	if b.gated {
+		b.stopRecovery()
+		b.changeState(pending, CauseManual)
+		b.lock.Unlock()
+		return
+	}
+	recovered := b.closeBreaker(CauseManual)
+	b.lock.Unlock()
+
+	if recovered && b.onClose != nil {
+		b.onClose()
+	}
 }
 
-func (b *Breaker) closeBreaker() {
-	b.changeState(closed)
+// Trip opens the breaker, exactly as if it had just seen enough errors to do so.
+// It will half-open again after the usual timeout, or if the breaker was built with
+// WithManualRecovery, stay open until HalfOpen or Reset is called.
+func (b *Breaker) Trip() {
+	b.lazyInit()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.openBreaker(CauseManual)
 }
 
-func (b *Breaker) timer() {
-	time.Sleep(b.timeout)
+// SetTimeout changes the breaker's timeout. If the breaker is currently open, its
+// pending transition to half-open is rescheduled to happen the new timeout after
+// the breaker opened (immediately, if that time has already passed), rather than
+// the new value only taking effect the next time it opens. As with New, a timeout
+// of zero or less means DefaultTimeout.
+func (b *Breaker) SetTimeout(timeout time.Duration) {
+	b.lazyInit()
+
+	timeout = validTimeout(timeout)
 
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
-	b.changeState(halfOpen)
+	b.timeout = timeout
+
+	if b.recovery != nil {
+		remaining := b.openedAt.Add(timeout).Sub(b.clock.Now())
+		if remaining < 0 {
+			remaining = 0
+		}
+		b.scheduleRecovery(remaining)
+	}
 }
 
-func (b *Breaker) changeState(newState uint32) {
+// errorsExpired reports whether the error window has passed since the last error.
+func (b *Breaker) errorsExpired() bool {
+	window := b.window
+	if window <= 0 {
+		window = b.timeout
+	}
+	return b.errors > 0 && b.clock.Now().After(b.lastError.Add(window))
+}
+
+func (b *Breaker) clearErrors() {
 	b.errors = 0
+	b.timeouts = 0
+	b.lastError = time.Time{}
+}
+
+func (b *Breaker) timeoutsReached() bool {
+	return b.timeoutAt > 0 && b.timeouts >= b.timeoutAt
+}
+
+func (b *Breaker) currentErrorThreshold() int {
+	if b.thresholdF != nil {
+		if threshold := b.thresholdF(); threshold > 0 {
+			return threshold
+		}
+	}
+	return b.errorThreshold
+}
+
+func (b *Breaker) openBreaker(cause Cause) {
+	b.changeState(open, cause)
+	b.openedAt = b.clock.Now()
+
+	if b.manual {
+		b.stopRecovery()
+		return
+	}
+	b.scheduleRecovery(b.timeout)
+}
+
+func (b *Breaker) scheduleRecovery(d time.Duration) {
+	// there is only ever one recovery pending; the generation guards against a
+	// stopped timer whose function had already started waiting on the lock
+	b.stopRecovery()
+	gen := b.recoveryGen
+	b.recovery = b.clock.AfterFunc(d, func() {
+		b.timerFired(gen)
+	})
+	atomic.StoreInt64(&b.counters.dueAt, b.clock.Now().Add(d).UnixNano())
+}
+
+// closeBreaker closes the breaker, reporting whether it had been open or half-open.
+func (b *Breaker) closeBreaker(cause Cause) bool {
+	recovered := b.state == open || b.state == halfOpen
+	b.stopRecovery()
+	b.changeState(closed, cause)
+	if b.budget != nil {
+		b.budget.fill(b.clock.Now())
+	}
+	return recovered
+}
+
+func (b *Breaker) stopRecovery() {
+	if b.recovery != nil {
+		b.recovery.Stop()
+		b.recovery = nil
+	}
+	b.recoveryGen++
+	atomic.StoreInt64(&b.counters.dueAt, 0)
+}
+
+func (b *Breaker) timerFired(gen uint64) {
+	b.lock.Lock()
+	if gen != b.recoveryGen || b.state != open {
+		b.lock.Unlock()
+		return
+	}
+	b.recovery = nil
+	atomic.StoreInt64(&b.counters.dueAt, 0)
+	b.changeState(halfOpen, CauseTimer)
+	b.lock.Unlock()
+
+	b.halfOpened()
+}
+
+// recoveryOverdue reports, without the lock, whether the breaker's recovery timer
+// should already have fired. The system clock runs its timers on time, so this
+// only checks (and so only pays for reading the time) with other Clocks.
+func (b *Breaker) recoveryOverdue() bool {
+	if _, ok := b.clock.(systemClock); ok {
+		return false
+	}
+	due := atomic.LoadInt64(&b.counters.dueAt)
+	return due != 0 && b.clock.Now().UnixNano() >= due
+}
+
+// catchUpRecovery is catchUp for the paths that otherwise read the state without
+// the lock, so only takes the lock if the recovery timer is overdue. It reports
+// whether it did, in which case the state may have changed.
+func (b *Breaker) catchUpRecovery() bool {
+	if !b.recoveryOverdue() {
+		return false
+	}
+	b.lock.Lock()
+	halfOpened := b.catchUp()
+	b.lock.Unlock()
+	if halfOpened {
+		b.halfOpened()
+	}
+	return true
+}
+
+// catchUp makes any changes that are due by the clock but that nothing has made
+// yet, so that what is read from the breaker, or decided by it, reflects the present
+// even after a long idle period: an elapsed error window is cleared, and an open
+// breaker whose recovery timer is late (as it may be with a Clock that only runs
+// timers when told to) half-opens. It must be called with the lock held, and
+// reports whether the breaker half-opened, in which case the caller must call
+// halfOpened once it has released the lock.
+func (b *Breaker) catchUp() bool {
+	switch b.state {
+	case closed:
+		if b.errorsExpired() {
+			b.clearErrors()
+		}
+	case degraded:
+		if b.errorsExpired() {
+			b.shiftTier(closed, CauseErrorsSubsided)
+		}
+	case open:
+		if b.recovery != nil && !b.clock.Now().Before(b.openedAt.Add(b.timeout)) {
+			b.stopRecovery()
+			b.changeState(halfOpen, CauseTimer)
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Breaker) halfOpened() {
+	if b.onHalfOpen != nil {
+		b.onHalfOpen()
+	}
+}
+
+// HalfOpen moves the breaker to half-open immediately, regardless of its current
+// state, cancelling any pending transition. This is how a breaker configured with
+// WithManualRecovery is allowed to start probing again. A breaker that is already
+// half-open is left as it is, keeping the progress its probes have made.
+func (b *Breaker) HalfOpen() {
+	b.lazyInit()
+
+	b.lock.Lock()
+	wasOpen := b.state == open
+	b.stopRecovery()
+	b.changeState(halfOpen, CauseManual)
+	b.lock.Unlock()
+
+	if wasOpen {
+		b.halfOpened()
+	}
+}
+
+// recoversAt returns when the breaker is due to half-open, or the zero Time if
+// it is not open or is waiting for manual recovery.
+func (b *Breaker) recoversAt() time.Time {
+	if b.state != open || b.manual {
+		return time.Time{}
+	}
+	return b.openedAt.Add(b.timeout)
+}
+
+// changeState moves the breaker to a new state, which always starts with nothing
+// counted: closed needs a full "errorThreshold" errors within the window (or a full
+// error budget) to open again, half-open needs "successThreshold" fresh successes
+// to close, and open counts nothing at all. No counter carries over between states.
+// The cause and time of the transition are kept for Stats. Moving to the state the
+// breaker is already in is not a transition, so does nothing and returns false;
+// progress made in that state is kept and in-flight calls still count.
+func (b *Breaker) changeState(newState uint32, cause Cause) bool {
+	if newState == b.state {
+		return false
+	}
+	b.transition.cause = cause
+	b.transition.at = b.clock.Now()
+	b.emitStateChange(newState)
+	b.clearErrors()
 	b.successes = 0
+	b.probes = 0
+	b.outstanding = 0
 	atomic.StoreUint32(&b.state, newState)
+	atomic.StoreUint32(&b.epoch, b.epoch+1)
+	b.notifyProbeReady()
+	return true
 }
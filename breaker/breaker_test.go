@@ -1,7 +1,11 @@
 package breaker
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -20,6 +24,195 @@ func returnsSuccess() error {
 	return nil
 }
 
+// testClock is a Clock that only moves when told to, running any timers that
+// become due synchronously from Advance.
+type testClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*testTimer
+}
+
+type testTimer struct {
+	clock *testClock
+	at    time.Time
+	fn    func()
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Unix(0, 0)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *testClock) AfterFunc(d time.Duration, fn func()) Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &testTimer{clock: c, at: c.now.Add(d), fn: fn}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	var due []*testTimer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = pending
+	c.lock.Unlock()
+
+	for _, t := range due {
+		t.fn()
+	}
+}
+
+// Skip moves the clock without running any timers, as if they were running late.
+func (c *testClock) Skip(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *testClock) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+func (t *testTimer) Stop() bool {
+	c := t.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fireRecovery synchronously performs the pending open to half-open transition,
+// as if the recovery timer had just fired, without waiting for it or depending
+// on the timer goroutine being scheduled. It works with any Clock and reports
+// whether a transition was pending.
+func (b *Breaker) fireRecovery() bool {
+	b.lock.Lock()
+	if b.recovery == nil {
+		b.lock.Unlock()
+		return false
+	}
+	b.recovery.Stop()
+	gen := b.recoveryGen
+	b.lock.Unlock()
+
+	b.timerFired(gen)
+	return true
+}
+
+func TestBreakerMeasurementWindow(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(2, 1, 1*time.Second, WithClock(clock), WithMeasurementWindow(1*time.Minute))
+
+	// errors further apart than the timeout still count together...
+	_ = breaker.Run(returnsError)
+	clock.Advance(30 * time.Second)
+	_ = breaker.Run(returnsError)
+	if breaker.State() != Open {
+		t.Fatal(breaker.State())
+	}
+
+	// ...while recovery still takes only the timeout
+	clock.Advance(1 * time.Second)
+	if breaker.State() != HalfOpen {
+		t.Fatal(breaker.State())
+	}
+	_ = breaker.Run(returnsSuccess)
+
+	// and errors further apart than the window don't
+	_ = breaker.Run(returnsError)
+	clock.Advance(61 * time.Second)
+	_ = breaker.Run(returnsError)
+	if stats := breaker.Stats(); stats.State != Closed || stats.Errors != 1 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerIdleCatchUp(t *testing.T) {
+	clock := newTestClock()
+	halfOpened := 0
+	breaker := NewWithOptions(2, 1, 1*time.Second, WithClock(clock), WithOnHalfOpen(func() { halfOpened++ }))
+
+	// after a long idle gap the stale error is no longer reported...
+	_ = breaker.Run(returnsError)
+	clock.Skip(1 * time.Hour)
+	if stats := breaker.Stats(); stats.Errors != 0 {
+		t.Errorf("%+v", stats)
+	}
+	if s := breaker.String(); s != "circuit breaker: closed, 0 errors, 0 successes" {
+		t.Error(s)
+	}
+
+	// ...and an open breaker whose timer is late is read as half-open
+	breaker.Trip()
+	clock.Skip(1 * time.Hour)
+	if stats := breaker.Stats(); stats.State != HalfOpen || stats.LastCause != CauseTimer || halfOpened != 1 {
+		t.Errorf("%+v", stats)
+	}
+
+	// or admits a call as a probe
+	breaker.Trip()
+	clock.Skip(1 * time.Hour)
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.State() != Closed || halfOpened != 2 {
+		t.Error(breaker.State(), halfOpened)
+	}
+
+	// the late timer firing in the end changes nothing
+	clock.Advance(0)
+	if breaker.State() != Closed || halfOpened != 2 || clock.Pending() != 0 {
+		t.Error(breaker.State(), halfOpened, clock.Pending())
+	}
+}
+
+func TestBreakerLateTimerReads(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(clock), WithHalfOpenHealthy())
+
+	// each of these sees the breaker half-open once its timer is late
+	checks := map[string]func() bool{
+		"State":   func() bool { return breaker.State() == HalfOpen },
+		"Healthy": breaker.Healthy,
+		"AllowProbe": func() bool {
+			if !breaker.AllowProbe() {
+				return false
+			}
+			breaker.MarkProbeResult(errSomeError)
+			return true
+		},
+		"ProbeReady": func() bool { return isReady(breaker.ProbeReady()) },
+	}
+	for name, check := range checks {
+		breaker.Trip()
+		clock.Skip(1 * time.Hour)
+		if !check() {
+			t.Error(name, "missed the late timer")
+		}
+	}
+}
+
 func TestBreakerErrorExpiry(t *testing.T) {
 	breaker := New(2, 1, 1*time.Second)
 
@@ -65,6 +258,31 @@ func TestBreakerPanicsCountAsErrors(t *testing.T) {
 	}
 }
 
+func TestBreakerPanicCapture(t *testing.T) {
+	var recovered interface{}
+	var stack []byte
+	breaker := NewWithOptions(3, 1, 1*time.Second, WithPanicCapture(func(r interface{}, s []byte) {
+		recovered, stack = r, s
+	}))
+
+	func() {
+		defer func() {
+			if val := recover(); val != "foo" {
+				t.Error("incorrect panic", val)
+			}
+		}()
+		_ = breaker.Run(alwaysPanics)
+	}()
+
+	if recovered != "foo" {
+		t.Error("incorrect capture", recovered)
+	}
+	// the trace must still reach the panicking function
+	if !strings.Contains(string(stack), "breaker.alwaysPanics") {
+		t.Errorf("stack does not include the panic site:\n%s", stack)
+	}
+}
+
 func TestBreakerStateTransitions(t *testing.T) {
 	breaker := New(3, 2, 1*time.Second)
 
@@ -174,6 +392,977 @@ func TestBreakerAsyncStateTransitions(t *testing.T) {
 	}
 }
 
+func TestBreakerOnHalfOpen(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	breaker := NewWithOptions(1, 1, 10*time.Millisecond, WithOnHalfOpen(func() {
+		calls <- struct{}{}
+	}))
+
+	// each trip should fire the hook exactly once when the timer half-opens it
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+
+		select {
+		case <-calls:
+		case <-time.After(1 * time.Second):
+			t.Fatal("hook not called")
+		}
+
+		select {
+		case <-calls:
+			t.Error("hook called more than once")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestBreakerProbeLimit(t *testing.T) {
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithHalfOpenProbes(1))
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	// fully open is reported as plain ErrBreakerOpen
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	if !breaker.fireRecovery() {
+		t.Fatal("no recovery pending")
+	}
+
+	// occupy the only probe slot
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- breaker.Run(func() error {
+			<-release
+			return nil
+		})
+	}()
+	for {
+		breaker.lock.Lock()
+		probes := breaker.probes
+		breaker.lock.Unlock()
+		if probes == 1 {
+			break
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	err := breaker.Run(returnsSuccess)
+	if err != ErrProbeLimitReached {
+		t.Error(err)
+	}
+	if !errors.Is(err, ErrBreakerOpen) {
+		t.Error("ErrProbeLimitReached should match ErrBreakerOpen")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	// the probe succeeded so the breaker is closed and no longer limited
+	for i := 0; i < 3; i++ {
+		if err := breaker.Run(returnsSuccess); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestBreakerFireRecovery(t *testing.T) {
+	halfOpened := 0
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithOnHalfOpen(func() {
+		halfOpened++
+	}))
+
+	if breaker.fireRecovery() {
+		t.Error("recovery pending while closed")
+	}
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if !breaker.fireRecovery() {
+		t.Fatal("no recovery pending while open")
+	}
+	if breaker.state != halfOpen || halfOpened != 1 {
+		t.Errorf("state %d after %d half-open hooks", breaker.state, halfOpened)
+	}
+	if breaker.fireRecovery() {
+		t.Error("recovery still pending after firing")
+	}
+
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.state != closed {
+		t.Error("breaker did not close")
+	}
+}
+
+func TestBreakerNilClock(t *testing.T) {
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(nil))
+
+	// the configuration is kept rather than replaced with the defaults
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.State() != Open || breaker.timeout != 1*time.Second {
+		t.Error(breaker.State(), breaker.timeout)
+	}
+}
+
+func TestBreakerEmbedded(t *testing.T) {
+	// misaligns the breaker on 32-bit platforms
+	var s struct {
+		flag    bool
+		breaker Breaker
+	}
+
+	if err := s.breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if stats := s.breaker.Stats(); stats.PeakInFlight != 1 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerZeroValue(t *testing.T) {
+	var breaker Breaker
+
+	// first use from many goroutines at once must initialise exactly once
+	var wg sync.WaitGroup
+	for i := 0; i < DefaultErrorThreshold-1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := breaker.Run(returnsError); err != errSomeError {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if breaker.errorThreshold != DefaultErrorThreshold ||
+		breaker.successThreshold != DefaultSuccessThreshold ||
+		breaker.timeout != DefaultTimeout {
+		t.Errorf("defaults not applied: %d %d %v", breaker.errorThreshold, breaker.successThreshold, breaker.timeout)
+	}
+	if breaker.State() != Closed {
+		t.Error("opened before the default threshold")
+	}
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if !breaker.fireRecovery() {
+		t.Fatal("no recovery pending")
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.State() != Closed {
+		t.Error("did not close after the default success threshold")
+	}
+}
+
+func TestBreakerRunContextExecuted(t *testing.T) {
+	breaker := New(1, 1, 1*time.Hour)
+	inner := New(1, 1, 1*time.Hour)
+	_ = inner.Run(returnsError)
+
+	// an open nested breaker fails the outer call, but the outer call still ran
+	executed, err := breaker.RunContextExecuted(context.Background(), func(context.Context) error {
+		return inner.Run(returnsSuccess)
+	})
+	if !executed || err != ErrBreakerOpen {
+		t.Error(executed, err)
+	}
+
+	// that failure opened the outer breaker, so this one is short-circuited
+	executed, err = breaker.RunContextExecuted(context.Background(), func(context.Context) error {
+		return nil
+	})
+	if executed || err != ErrBreakerOpen {
+		t.Error(executed, err)
+	}
+}
+
+func TestBreakerResetAndTrip(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(2, 1, 1*time.Second, WithClock(clock))
+
+	breaker.Trip()
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); !stats.RecoversAt.Equal(clock.Now().Add(1*time.Second)) || stats.RecoversIn != 1*time.Second {
+		t.Error("recovers at", stats.RecoversAt, stats.RecoversIn)
+	}
+
+	breaker.Reset()
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); stats.State != Closed || stats.Errors != 1 || !stats.RecoversAt.IsZero() {
+		t.Errorf("%+v", stats)
+	}
+
+	// the cancelled recovery must not half-open the breaker later
+	clock.Advance(1 * time.Second)
+	if breaker.State() != Closed {
+		t.Error(breaker.State())
+	}
+
+	// reset clears the error count
+	breaker.Reset()
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.State() != Closed {
+		t.Error(breaker.State())
+	}
+}
+
+func TestBreakerSetTimeout(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 10*time.Second, WithClock(clock))
+
+	// shortening the timeout while open brings the recovery forward
+	breaker.Trip()
+	clock.Advance(3 * time.Second)
+	breaker.SetTimeout(5 * time.Second)
+	if clock.Pending() != 1 {
+		t.Error(clock.Pending(), "timers pending")
+	}
+	clock.Advance(1 * time.Second)
+	if breaker.State() != Open {
+		t.Error("half-opened early")
+	}
+	clock.Advance(1 * time.Second)
+	if breaker.State() != HalfOpen {
+		t.Error("did not half-open at the new timeout")
+	}
+
+	// lengthening it pushes the recovery back
+	breaker.Trip()
+	clock.Advance(4 * time.Second)
+	breaker.SetTimeout(8 * time.Second)
+	clock.Advance(2 * time.Second)
+	if breaker.State() != Open {
+		t.Error("half-opened at the old timeout")
+	}
+	clock.Advance(2 * time.Second)
+	if breaker.State() != HalfOpen {
+		t.Error("did not half-open at the new timeout")
+	}
+
+	// a timeout that has already elapsed half-opens straight away
+	breaker.Trip()
+	clock.Advance(4 * time.Second)
+	breaker.SetTimeout(2 * time.Second)
+	clock.Advance(0)
+	if breaker.State() != HalfOpen {
+		t.Error("did not half-open immediately")
+	}
+
+	// and the new timeout applies to later trips
+	breaker.Trip()
+	if stats := breaker.Stats(); !stats.RecoversAt.Equal(clock.Now().Add(2 * time.Second)) {
+		t.Error("recovers at", stats.RecoversAt)
+	}
+}
+
+func TestBreakerExplicitProbes(t *testing.T) {
+	breaker := NewWithOptions(1, 2, 1*time.Hour, WithHalfOpenProbes(1))
+
+	if breaker.AllowProbe() {
+		t.Error("probe allowed while closed")
+	}
+	breaker.Trip()
+	if breaker.AllowProbe() {
+		t.Error("probe allowed while open")
+	}
+	breaker.fireRecovery()
+
+	// the external probe shares the budget with Run
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed while half-open")
+	}
+	if breaker.AllowProbe() {
+		t.Error("probe allowed beyond the limit")
+	}
+	if err := breaker.Run(returnsSuccess); err != ErrProbeLimitReached {
+		t.Error(err)
+	}
+
+	// and its success counts towards the threshold
+	breaker.MarkProbeResult(nil)
+	if breaker.State() != HalfOpen {
+		t.Error(breaker.State())
+	}
+	// unpaired results are ignored
+	breaker.MarkProbeResult(nil)
+	if breaker.State() != HalfOpen {
+		t.Error(breaker.State())
+	}
+	if !breaker.AllowProbe() {
+		t.Fatal("probe slot not released")
+	}
+	breaker.MarkProbeResult(nil)
+	if breaker.State() != Closed {
+		t.Error(breaker.State())
+	}
+
+	// a failed probe re-opens
+	breaker.Trip()
+	breaker.fireRecovery()
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed while half-open")
+	}
+	breaker.MarkProbeResult(errSomeError)
+	if breaker.State() != Open {
+		t.Error(breaker.State())
+	}
+}
+
+func TestBreakerFailureThresholdFunc(t *testing.T) {
+	threshold := 3
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithFailureThresholdFunc(func() int {
+		return threshold
+	}))
+
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if breaker.State() != Closed {
+		t.Fatal("opened below the dynamic threshold")
+	}
+
+	// the threshold is re-evaluated on every error
+	threshold = 5
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if breaker.State() != Closed {
+		t.Fatal("opened below the dynamic threshold")
+	}
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.State() != Open {
+		t.Fatal("did not open at the dynamic threshold")
+	}
+
+	// non-positive values fall back to the static threshold of 1
+	breaker.Reset()
+	threshold = -2
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.State() != Open {
+		t.Fatal("did not fall back to the static threshold")
+	}
+}
+
+func TestBreakerCountersDoNotCarryOver(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(2, 2, 1*time.Second, WithClock(clock))
+
+	// errors that trip the breaker...
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	clock.Advance(1 * time.Second)
+
+	// ...and a partial recovery that fails...
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); stats.Successes != 1 {
+		t.Errorf("%+v", stats)
+	}
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); stats.State != Open || stats.Errors != 0 || stats.Successes != 0 {
+		t.Errorf("half-open failure carried into open: %+v", stats)
+	}
+
+	// ...leave the next half-open period needing a full set of successes...
+	clock.Advance(1 * time.Second)
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.State() != HalfOpen {
+		t.Error("earlier half-open success carried over")
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); stats.State != Closed || stats.Errors != 0 || stats.Successes != 0 {
+		t.Errorf("%+v", stats)
+	}
+
+	// ...and the closed breaker needing a full set of errors, even within the
+	// window of the errors that originally tripped it
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.State() != Closed {
+		t.Error("errors from before the trip carried over")
+	}
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.State() != Open {
+		t.Error("did not open")
+	}
+}
+
+type retryError struct {
+	name    string
+	retryAt time.Time
+}
+
+func (e retryError) Error() string {
+	return fmt.Sprintf("%s is unavailable until %v", e.name, e.retryAt)
+}
+
+func (e retryError) Unwrap() error {
+	return ErrBreakerOpen
+}
+
+func TestBreakerOpenErrorFormatter(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 5*time.Second, WithClock(clock), WithName("db"),
+		WithOpenErrorFormatter(func(name string, retryAt time.Time) error {
+			return retryError{name, retryAt}
+		}))
+
+	breaker.Trip()
+	clock.Advance(1 * time.Second)
+
+	executed, err := breaker.RunContextExecuted(context.Background(), func(context.Context) error {
+		return nil
+	})
+	if executed {
+		t.Error("executed while open")
+	}
+	if re, ok := err.(retryError); !ok || re.name != "db" || !re.retryAt.Equal(time.Unix(5, 0)) {
+		t.Errorf("%#v", err)
+	}
+	if !errors.Is(err, ErrBreakerOpen) {
+		t.Error("formatted error does not match ErrBreakerOpen")
+	}
+
+	// the default is unchanged, even for named breakers
+	plain := NewWithOptions(1, 1, 1*time.Second, WithName("db"))
+	plain.Trip()
+	if err := plain.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+}
+
+func isReady(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBreakerProbeReady(t *testing.T) {
+	breaker := NewWithOptions(1, 2, 1*time.Hour, WithHalfOpenProbes(1))
+
+	if !isReady(breaker.ProbeReady()) {
+		t.Error("not ready while closed")
+	}
+
+	// waiting through open to half-open
+	breaker.Trip()
+	ready := breaker.ProbeReady()
+	if isReady(ready) {
+		t.Error("ready while open")
+	}
+	breaker.fireRecovery()
+	if !isReady(ready) {
+		t.Error("not signalled on half-open")
+	}
+
+	// waiting for a probe slot to free up
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed")
+	}
+	ready = breaker.ProbeReady()
+	if isReady(ready) {
+		t.Error("ready with no probe slot free")
+	}
+	breaker.MarkProbeResult(nil)
+	if !isReady(ready) {
+		t.Error("not signalled when the slot was released")
+	}
+
+	// waiting while the probe slot is held, then reset
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed")
+	}
+	ready = breaker.ProbeReady()
+	breaker.Reset()
+	if !isReady(ready) {
+		t.Error("not signalled on reset")
+	}
+
+	// a probe that re-opens the breaker doesn't signal until half-open again
+	breaker.Trip()
+	breaker.fireRecovery()
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed")
+	}
+	ready = breaker.ProbeReady()
+	breaker.MarkProbeResult(errSomeError)
+	if isReady(ready) {
+		t.Error("signalled when the breaker re-opened")
+	}
+	breaker.fireRecovery()
+	if !isReady(ready) {
+		t.Error("not signalled on half-open")
+	}
+}
+
+func TestBreakerNonPositiveTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -1 * time.Second} {
+		clock := newTestClock()
+		breaker := NewWithOptions(1, 1, timeout, WithClock(clock))
+		if breaker.timeout != DefaultTimeout {
+			t.Error(timeout, "gave", breaker.timeout)
+		}
+
+		// the breaker stays open rather than flapping straight to half-open
+		breaker.Trip()
+		clock.Advance(0)
+		if breaker.State() != Open {
+			t.Error(timeout, "did not stay open")
+		}
+		clock.Advance(DefaultTimeout)
+		if breaker.State() != HalfOpen {
+			t.Error(timeout, "did not half-open")
+		}
+
+		breaker.SetTimeout(1 * time.Second)
+		breaker.SetTimeout(timeout)
+		if breaker.timeout != DefaultTimeout {
+			t.Error(timeout, "set", breaker.timeout)
+		}
+	}
+}
+
+func TestBreakerManualRecovery(t *testing.T) {
+	clock := newTestClock()
+	halfOpened := 0
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(clock), WithName("db"), WithManualRecovery(),
+		WithOnHalfOpen(func() { halfOpened++ }))
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if clock.Pending() != 0 {
+		t.Error("recovery scheduled")
+	}
+	clock.Advance(1 * time.Hour)
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	stats := breaker.Stats()
+	if stats.State != Open || !stats.ManualHold || !stats.RecoversAt.IsZero() {
+		t.Errorf("%+v", stats)
+	}
+	if s := breaker.String(); s != `circuit breaker "db": open, 0 errors, 0 successes, held for manual recovery` {
+		t.Error(s)
+	}
+
+	// an operator lets it probe
+	breaker.HalfOpen()
+	if breaker.State() != HalfOpen || halfOpened != 1 || breaker.Stats().ManualHold {
+		t.Error(breaker.State(), halfOpened)
+	}
+
+	// a failed probe holds it open again
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	clock.Advance(1 * time.Hour)
+	if !breaker.Stats().ManualHold {
+		t.Error("not held after failed probe")
+	}
+
+	breaker.Reset()
+	if breaker.State() != Closed {
+		t.Error(breaker.State())
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(clock))
+
+	// from open it cancels the pending recovery
+	breaker.Trip()
+	breaker.HalfOpen()
+	if breaker.State() != HalfOpen || clock.Pending() != 0 {
+		t.Error(breaker.State(), clock.Pending())
+	}
+
+	// and it works from closed too
+	breaker.Reset()
+	breaker.HalfOpen()
+	if breaker.State() != HalfOpen {
+		t.Error(breaker.State())
+	}
+}
+
+func TestBreakerSameStateNoop(t *testing.T) {
+	halfOpened := 0
+	breaker := NewWithOptions(1, 2, 1*time.Hour, WithOnHalfOpen(func() { halfOpened++ }))
+
+	breaker.Trip()
+	breaker.HalfOpen()
+	adm, err := breaker.admit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = breaker.Run(returnsSuccess)
+
+	// a second HalfOpen neither fires the callback nor discards progress or
+	// the call still in flight
+	breaker.HalfOpen()
+	if halfOpened != 1 {
+		t.Error("callback fired", halfOpened)
+	}
+	stats := breaker.Stats()
+	if stats.State != HalfOpen || stats.Successes != 1 || stats.LastCause != CauseManual {
+		t.Errorf("%+v", stats)
+	}
+	breaker.finish(adm, Success, nil)
+	if breaker.State() != Closed {
+		t.Error(breaker.State())
+	}
+
+	// Reset still clears the counters of a closed breaker
+	breaker = New(2, 1, 1*time.Hour)
+	_ = breaker.Run(returnsError)
+	breaker.Reset()
+	if stats := breaker.Stats(); stats.Errors != 0 || stats.LastCause != CauseNone {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerOnClose(t *testing.T) {
+	closes := 0
+	var breaker *Breaker
+	breaker = NewWithOptions(1, 2, 1*time.Hour, WithOnClose(func() {
+		closes++
+		// called without the lock held
+		_ = breaker.Stats()
+	}))
+
+	// a reset of a closed breaker is not a recovery
+	breaker.Reset()
+	if closes != 0 {
+		t.Error(closes)
+	}
+
+	// recovering through probes fires once, on the probe that closes it
+	_ = breaker.Run(returnsError)
+	breaker.fireRecovery()
+	_ = breaker.Run(returnsSuccess)
+	if closes != 0 {
+		t.Error(closes)
+	}
+	_ = breaker.Run(returnsSuccess)
+	_ = breaker.Run(returnsSuccess)
+	if closes != 1 {
+		t.Error(closes)
+	}
+
+	// resetting an open breaker is
+	breaker.Trip()
+	breaker.Reset()
+	breaker.Reset()
+	if closes != 2 {
+		t.Error(closes)
+	}
+}
+
+func TestBreakerStartupGatePasses(t *testing.T) {
+	breaker := NewWithOptions(2, 2, 1*time.Hour, WithStartupGate())
+
+	if breaker.State() != Pending {
+		t.Fatal(breaker.State())
+	}
+
+	// failures short of the threshold and successes are all admitted
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.State() != Pending {
+		t.Fatal(breaker.State())
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.State() != Closed {
+		t.Fatal(breaker.State())
+	}
+
+	// once closed it behaves normally, and Reset brings back the gate
+	_ = breaker.Run(returnsError)
+	if breaker.State() != Closed {
+		t.Fatal(breaker.State())
+	}
+	breaker.Reset()
+	if stats := breaker.Stats(); stats.State != Pending || stats.Errors != 0 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerStartupGateFails(t *testing.T) {
+	breaker := NewWithOptions(2, 3, 1*time.Hour, WithStartupGate())
+
+	_ = breaker.Run(returnsError)
+	_ = breaker.Run(returnsSuccess)
+	_ = breaker.Run(returnsError)
+	if breaker.State() != Open {
+		t.Fatal(breaker.State())
+	}
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	// recovering from open closes it directly, without the gate
+	breaker.fireRecovery()
+	for i := 0; i < 3; i++ {
+		_ = breaker.Run(returnsSuccess)
+	}
+	if breaker.State() != Closed {
+		t.Fatal(breaker.State())
+	}
+}
+
+func TestBreakerRecordResults(t *testing.T) {
+	breaker := NewWithOptions(2, 2, 1*time.Hour, WithHalfOpenProbes(1),
+		WithResultClassifier(func(err error) Outcome {
+			if err == errDegraded {
+				return Ignore
+			}
+			return DefaultClassifier(err)
+		}))
+
+	breaker.RecordFailure(errDegraded)
+	breaker.RecordFailure(errSomeError)
+	if stats := breaker.Stats(); stats.State != Closed || stats.Errors != 1 {
+		t.Errorf("%+v", stats)
+	}
+	breaker.RecordFailure(errSomeError)
+	if breaker.State() != Open {
+		t.Fatal(breaker.State())
+	}
+
+	// ignored while open
+	breaker.RecordSuccess()
+	if stats := breaker.Stats(); stats.State != Open || stats.Successes != 0 {
+		t.Errorf("%+v", stats)
+	}
+
+	// while half-open recorded results count as probes without taking the slot
+	breaker.fireRecovery()
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed")
+	}
+	breaker.RecordSuccess()
+	if stats := breaker.Stats(); stats.State != HalfOpen || stats.Successes != 1 || stats.ProbesAttempted != 2 {
+		t.Errorf("%+v", stats)
+	}
+	breaker.MarkProbeResult(nil)
+	if breaker.State() != Closed {
+		t.Fatal(breaker.State())
+	}
+}
+
+func TestBreakerRecordResultsZeroValue(t *testing.T) {
+	var breaker Breaker
+
+	breaker.RecordSuccess()
+	for i := 0; i < DefaultErrorThreshold; i++ {
+		breaker.RecordFailure(errSomeError)
+	}
+	if breaker.State() != Open {
+		t.Error(breaker.State())
+	}
+}
+
+func TestBreakerNilFunc(t *testing.T) {
+	breaker := New(1, 1, 1*time.Hour)
+
+	if err := breaker.Run(nil); err != ErrNilFunc {
+		t.Error(err)
+	}
+	if err := breaker.Go(nil); err != ErrNilFunc {
+		t.Error(err)
+	}
+	if err := breaker.RunContext(context.Background(), nil); err != ErrNilFunc {
+		t.Error(err)
+	}
+	if executed, err := breaker.RunContextExecuted(context.Background(), nil); executed || err != ErrNilFunc {
+		t.Error(executed, err)
+	}
+	if err := NewComposite(All, breaker).Run(nil); err != ErrNilFunc {
+		t.Error(err)
+	}
+
+	if stats := breaker.Stats(); stats.State != Closed || stats.Errors != 0 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerRunContextRejectedPrecedence(t *testing.T) {
+	breaker := New(1, 1, 1*time.Hour)
+	breaker.Trip()
+
+	// a live context gets the breaker's error
+	if err := breaker.RunContext(context.Background(), contextSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	// a done one gets its own, whichever way it was done
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := breaker.RunContext(canceled, contextSuccess); err != context.Canceled {
+		t.Error(err)
+	}
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-1*time.Second))
+	defer cancel()
+	if executed, err := breaker.RunContextExecuted(expired, contextSuccess); executed || err != context.DeadlineExceeded {
+		t.Error(executed, err)
+	}
+
+	// and a closed breaker still runs the function, whatever state ctx is in
+	breaker.Reset()
+	if err := breaker.RunContext(canceled, contextSuccess); err != nil {
+		t.Error(err)
+	}
+}
+
+func contextSuccess(ctx context.Context) error {
+	return nil
+}
+
+func TestBreakerStaleResultsIgnored(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(clock))
+
+	// a slow call is admitted while closed...
+	slow, err := breaker.admit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...the breaker trips and half-opens while it is running...
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	clock.Advance(1 * time.Second)
+
+	// ...so its eventual success must not close the breaker
+	breaker.processResult(slow, Success, 1)
+	if breaker.state != halfOpen {
+		t.Error("stale success changed the breaker state")
+	}
+}
+
+func TestBreakerZeroThresholds(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(0, 0, 1*time.Second, WithClock(clock))
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if err := breaker.Run(returnsError); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	clock.Advance(1 * time.Second)
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.state != closed {
+		t.Error("breaker did not close")
+	}
+}
+
+// The benchmarks wrap functions that do nothing, so that they measure only the
+// overhead the breaker itself adds to each call.
+
+func Benchmark_Run_Closed(b *testing.B) {
+	breaker := New(1, 1, 1*time.Hour)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = breaker.Run(returnsSuccess)
+	}
+}
+
+func Benchmark_Run_Open(b *testing.B) {
+	breaker := New(1, 1, 1*time.Hour)
+	breaker.Trip()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = breaker.Run(returnsSuccess)
+	}
+}
+
+func Benchmark_Run_Contended(b *testing.B) {
+	// errors expire long before they could reach the threshold, so the breaker
+	// stays closed while one call in ten still has to take the lock
+	breaker := New(1<<30, 1, 1*time.Nanosecond)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%10 == 0 {
+				_ = breaker.Run(returnsError)
+			} else {
+				_ = breaker.Run(returnsSuccess)
+			}
+			i++
+		}
+	})
+}
+
 func ExampleBreaker() {
 	breaker := New(3, 1, 5*time.Second)
 
@@ -0,0 +1,59 @@
+package breaker
+
+import "time"
+
+// WithErrorBudget replaces the breaker's error threshold with an error budget. The
+// budget starts full with "capacity" tokens, each failure while closed spends one,
+// and tokens are refilled continuously at "refillPerSec" per second up to the
+// capacity. The breaker opens when a failure exhausts the budget, so a burst of
+// "capacity" failures opens it but a sustained failure rate below "refillPerSec"
+// never will. The budget is full again whenever the breaker closes.
+func WithErrorBudget(capacity int, refillPerSec float64) Option {
+	return func(b *Breaker) {
+		b.budget = &errorBudget{
+			capacity: float64(capacity),
+			rate:     refillPerSec,
+			tokens:   float64(capacity),
+		}
+	}
+}
+
+type errorBudget struct {
+	capacity, rate float64
+	tokens         float64
+	refilled       time.Time // zero until first used, counting as full
+}
+
+// available returns the number of tokens in the budget at the given time.
+func (e *errorBudget) available(now time.Time) float64 {
+	if e.refilled.IsZero() || !now.After(e.refilled) {
+		return e.tokens
+	}
+
+	// computed in floating point so that arbitrarily long idle periods can't
+	// overflow; anything that comes out larger than the capacity (including
+	// +Inf) is simply capped
+	tokens := e.tokens + now.Sub(e.refilled).Seconds()*e.rate
+	if !(tokens < e.capacity) {
+		tokens = e.capacity
+	}
+	return tokens
+}
+
+// spend takes a token for a failure at the given time, reporting false if that
+// exhausted the budget.
+func (e *errorBudget) spend(now time.Time) bool {
+	e.tokens = e.available(now) - 1
+	e.refilled = now
+
+	if e.tokens <= 0 {
+		e.tokens = 0
+		return false
+	}
+	return true
+}
+
+func (e *errorBudget) fill(now time.Time) {
+	e.tokens = e.capacity
+	e.refilled = now
+}
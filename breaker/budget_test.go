@@ -0,0 +1,82 @@
+package breaker
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBreakerErrorBudget(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Minute, WithClock(clock), WithErrorBudget(3, 2))
+
+	// a burst of two failures doesn't exhaust the budget...
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if stats := breaker.Stats(); stats.State != Closed || stats.Budget != 1 {
+		t.Errorf("%+v", stats)
+	}
+
+	// ...and it refills over time
+	clock.Advance(500 * time.Millisecond)
+	if stats := breaker.Stats(); stats.Budget != 2 {
+		t.Errorf("%+v", stats)
+	}
+
+	// failing at the refill rate is sustainable
+	for i := 0; i < 10; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+		clock.Advance(500 * time.Millisecond)
+	}
+	if breaker.State() != Closed {
+		t.Fatal("sustainable failure rate opened the breaker")
+	}
+
+	// but a burst that spends the two tokens left is not
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if err := breaker.Run(returnsError); err != ErrBreakerOpen {
+		t.Fatal("exhausted budget did not open the breaker")
+	}
+
+	// the budget is full again on recovery
+	clock.Advance(1 * time.Minute)
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); stats.State != Closed || stats.Budget != 3 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestErrorBudgetLongIdle(t *testing.T) {
+	start := time.Unix(0, 0)
+	budget := &errorBudget{capacity: 5, rate: math.MaxFloat64, tokens: 5}
+
+	if !budget.spend(start) {
+		t.Fatal("budget exhausted")
+	}
+	// an enormous refill (here +Inf) is capped at the capacity
+	later := start.Add(math.MaxInt64)
+	if got := budget.available(later); got != 5 {
+		t.Error(got)
+	}
+
+	// the clock going backwards refills nothing but doesn't break anything
+	budget.tokens = 1
+	budget.refilled = later
+	if got := budget.available(start); got != 1 {
+		t.Error(got)
+	}
+	if budget.spend(start) {
+		t.Error("budget not exhausted")
+	}
+}
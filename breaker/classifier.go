@@ -0,0 +1,98 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// Outcome is the type returned by a result classifier to indicate how the Breaker
+// should treat the result of a call.
+type Outcome int
+
+const (
+	Success Outcome = iota // Success indicates the call counts towards closing the breaker.
+	Failure                // Failure indicates the call counts towards opening the breaker.
+	Ignore                 // Ignore indicates the call does not affect the breaker at all.
+	Timeout                // Timeout indicates a Failure that also counts towards WithTimeoutThreshold.
+)
+
+// DefaultClassifier classifies results in the simplest way possible. If the
+// error is nil, it returns Success. If it is (or wraps) context.DeadlineExceeded or
+// an error with a Timeout method that returns true, such as a net.Error for a
+// timeout, it returns Timeout, and otherwise it returns Failure.
+//
+// Classifiers that wrap this one must handle Timeout as well as Failure, rather than
+// comparing its result with Failure alone; the breaker treats the two alike except
+// for WithTimeoutThreshold.
+func DefaultClassifier(err error) Outcome {
+	if err == nil {
+		return Success
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout()) {
+		return Timeout
+	}
+
+	return Failure
+}
+
+// WithTimeoutThreshold gives timeouts a trip threshold of their own, typically lower
+// than the error threshold, since a dependency that hangs does more harm than one
+// that fails quickly. A closed breaker then opens once "n" timeouts are seen within
+// the error window, even if that is fewer errors than its error threshold; timeouts
+// still count as errors towards that threshold too. Timeouts are the results
+// classified as Timeout, along with RunContext calls that run out of time. Like the
+// error threshold, this is not used with WithErrorBudget.
+func WithTimeoutThreshold(n int) Option {
+	return func(b *Breaker) {
+		b.timeoutAt = n
+	}
+}
+
+// WithResultClassifier makes the breaker use the given function to decide how the
+// result of each call affects it, in place of DefaultClassifier. A call that panics
+// is always a Failure, regardless of the classifier. A nil classifier means
+// DefaultClassifier.
+func WithResultClassifier(classify func(error) Outcome) Option {
+	if classify == nil {
+		classify = DefaultClassifier
+	}
+	return func(b *Breaker) {
+		b.classify = classify
+	}
+}
+
+// IgnoreResult wraps err so that, returned from a function run by a Breaker, the
+// call is classified as Ignore whatever the breaker's classifier would make of err.
+// It is for code that wraps calls on the caller's behalf and knows better than the
+// classifier, such as package bnet. The wrapped error reads the same as err and
+// unwraps to it, so errors.Is and errors.As see through it. IgnoreResult(nil) is nil.
+func IgnoreResult(err error) error {
+	if err == nil {
+		return nil
+	}
+	return ignoredResult{err}
+}
+
+type ignoredResult struct {
+	err error
+}
+
+func (e ignoredResult) Error() string { return e.err.Error() }
+func (e ignoredResult) Unwrap() error { return e.err }
+
+// classifyResult classifies the result of a call, counting those it ignores.
+func (b *Breaker) classifyResult(err error) Outcome {
+	var outcome Outcome
+	if _, ok := err.(ignoredResult); ok {
+		outcome = Ignore
+	} else {
+		outcome = b.classify(err)
+	}
+	if outcome == Ignore {
+		atomic.AddUint64(&b.counters.ignored, 1)
+	}
+	return outcome
+}
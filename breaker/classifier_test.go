@@ -0,0 +1,276 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDefaultClassifier(t *testing.T) {
+	if DefaultClassifier(nil) != Success {
+		t.Error("default misclassified nil")
+	}
+	if DefaultClassifier(errSomeError) != Failure {
+		t.Error("default misclassified errSomeError")
+	}
+	if DefaultClassifier(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)) != Timeout {
+		t.Error("default misclassified a deadline")
+	}
+	if DefaultClassifier(timeoutError(true)) != Timeout || DefaultClassifier(timeoutError(false)) != Failure {
+		t.Error("default misclassified a Timeout method")
+	}
+}
+
+type timeoutError bool
+
+func (e timeoutError) Error() string { return "timeout" }
+func (e timeoutError) Timeout() bool { return bool(e) }
+
+func TestBreakerTimeoutThreshold(t *testing.T) {
+	breaker := NewWithOptions(4, 1, 1*time.Hour, WithTimeoutThreshold(2))
+	returnsTimeout := func() error { return timeoutError(true) }
+
+	// timeouts trip the breaker at their own threshold...
+	_ = breaker.Run(returnsError)
+	_ = breaker.Run(returnsTimeout)
+	if stats := breaker.Stats(); stats.State != Closed || stats.Errors != 2 || stats.Timeouts != 1 {
+		t.Errorf("%+v", stats)
+	}
+	_ = breaker.Run(returnsTimeout)
+	if breaker.State() != Open {
+		t.Fatal(breaker.State())
+	}
+
+	// ...while other errors still need the full error threshold, counting any
+	// timeouts among them
+	breaker.Reset()
+	for i := 0; i < 3; i++ {
+		_ = breaker.Run(returnsError)
+	}
+	if breaker.State() != Closed {
+		t.Fatal(breaker.State())
+	}
+	_ = breaker.Run(returnsTimeout)
+	if breaker.State() != Open {
+		t.Fatal(breaker.State())
+	}
+}
+
+func TestBreakerTimeoutThresholdContext(t *testing.T) {
+	breaker := NewWithOptions(5, 1, 1*time.Hour, WithTimeoutThreshold(1), WithCallTimeout(1*time.Millisecond))
+
+	err := breaker.RunContext(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return errSomeError // not itself recognisable as a timeout
+	})
+	if err != errSomeError || breaker.State() != Open {
+		t.Error(err, breaker.State())
+	}
+}
+
+func TestBreakerInvertedClassifier(t *testing.T) {
+	breaker := NewWithOptions(2, 1, 1*time.Second, WithResultClassifier(func(err error) Outcome {
+		if err == nil {
+			return Failure
+		}
+		return Success
+	}))
+
+	// errors are healthy, so they never open the breaker...
+	for i := 0; i < 5; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+
+	// ...but successes do
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(returnsSuccess); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+}
+
+func TestBreakerNilClassifier(t *testing.T) {
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithResultClassifier(nil))
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.State() != Open {
+		t.Error(breaker.State())
+	}
+}
+
+func TestBreakerIgnoreClassifier(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(clock), WithHalfOpenProbes(1),
+		WithResultClassifier(func(err error) Outcome {
+			if err == errSomeError {
+				return Ignore
+			}
+			return DefaultClassifier(err)
+		}))
+
+	// ignored errors are still passed back but don't open the breaker
+	for i := 0; i < 3; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+
+	// panics are failures regardless of the classifier
+	func() {
+		defer func() {
+			_ = recover()
+		}()
+		_ = breaker.Run(alwaysPanics)
+	}()
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	// an ignored probe releases its slot without closing or re-opening
+	clock.Advance(1 * time.Second)
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.state != halfOpen || breaker.probes != 0 {
+		t.Errorf("state %d with %d probes after ignored probe", breaker.state, breaker.probes)
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.state != closed {
+		t.Error("breaker did not close")
+	}
+
+	// the ignored results were all counted, but not the rejection or panic
+	if stats := breaker.Stats(); stats.Ignored != 4 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestIgnoreResult(t *testing.T) {
+	breaker := New(1, 1, 1*time.Hour)
+
+	// the classifier would count it, but the function knows better
+	err := breaker.Run(func() error {
+		return IgnoreResult(errSomeError)
+	})
+	if !errors.Is(err, errSomeError) || err.Error() != errSomeError.Error() {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); stats.State != Closed || stats.Ignored != 1 {
+		t.Errorf("%+v", stats)
+	}
+	if IgnoreResult(nil) != nil {
+		t.Error("IgnoreResult(nil) is not nil")
+	}
+}
+
+var errDegraded = errors.New("errDegraded")
+
+func TestBreakerSuccessWeight(t *testing.T) {
+	breaker := NewWithOptions(1, 2, 1*time.Hour,
+		WithResultClassifier(func(err error) Outcome {
+			if err == errDegraded {
+				return Success
+			}
+			return DefaultClassifier(err)
+		}),
+		WithSuccessWeight(func(err error) float64 {
+			if err == errDegraded {
+				return 0.5
+			}
+			return 7 // clamped to 1
+		}))
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	breaker.fireRecovery()
+
+	// three degraded responses are only worth 1.5 of the 2 needed
+	for i := 0; i < 3; i++ {
+		if err := breaker.Run(func() error { return errDegraded }); err != errDegraded {
+			t.Error(err)
+		}
+	}
+	if breaker.State() != HalfOpen {
+		t.Error("closed on too little credit")
+	}
+
+	// one more degraded response brings it to 2
+	if err := breaker.Run(func() error { return errDegraded }); err != errDegraded {
+		t.Error(err)
+	}
+	if breaker.State() != Closed {
+		t.Error("did not close")
+	}
+
+	// a clean success is worth at most 1
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	breaker.fireRecovery()
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.State() != HalfOpen {
+		t.Error("weight was not clamped")
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.State() != Closed {
+		t.Error("did not close")
+	}
+}
+
+func TestBreakerSuccessWeightPending(t *testing.T) {
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithStartupGate(), WithSuccessWeight(func(error) float64 {
+		return 0.5
+	}))
+
+	// the startup gate weighs successes just as half-open does
+	_ = breaker.Run(returnsSuccess)
+	if stats := breaker.Stats(); stats.State != Pending || stats.Successes != 0.5 {
+		t.Errorf("%+v", stats)
+	}
+	_ = breaker.Run(returnsSuccess)
+	if breaker.State() != Closed {
+		t.Error(breaker.State())
+	}
+}
+
+func TestBreakerSuccessWeightNaN(t *testing.T) {
+	nan := true
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithSuccessWeight(func(err error) float64 {
+		if nan {
+			return math.NaN()
+		}
+		return 1
+	}))
+
+	// NaN earns no credit, but doesn't poison the credit earned later
+	breaker.Trip()
+	breaker.fireRecovery()
+	for i := 0; i < 3; i++ {
+		_ = breaker.Run(returnsSuccess)
+	}
+	if stats := breaker.Stats(); stats.State != HalfOpen || stats.Successes != 0 {
+		t.Errorf("%+v", stats)
+	}
+	nan = false
+	_ = breaker.Run(returnsSuccess)
+	if breaker.State() != Closed {
+		t.Error(breaker.State())
+	}
+}
@@ -0,0 +1,32 @@
+package breaker
+
+import "time"
+
+// Clock is the source of time used by a Breaker, both for expiring errors and for
+// scheduling the transition from open to half-open. The default uses the time
+// package; tests may inject their own implementation with WithClock.
+//
+// The function passed to AfterFunc performs the transition to half-open before it
+// returns, so a test Clock that calls it directly (rather than from a goroutine)
+// can step the breaker deterministically without sleeping.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending function call scheduled with Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the call from happening, returning false if it has already
+	// happened or been stopped.
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,120 @@
+package breaker
+
+// Policy is the type used by a Composite to decide whether to run a function
+// based on its member breakers.
+type Policy int
+
+const (
+	// All runs the function only if every member admits it, for operations
+	// that need all of their dependencies. The result counts against every
+	// member.
+	All Policy = iota
+	// Any runs the function if at least one member admits it, for operations
+	// that can be served by any of their dependencies. The result counts
+	// against only the first member (in order) that admitted it.
+	Any
+)
+
+// Composite combines several breakers into one that short-circuits according to
+// a Policy. The members remain ordinary breakers and may also be used directly. A
+// Composite with no members has nothing to admit a call, so with either policy it
+// rejects every call with ErrBreakerOpen.
+type Composite struct {
+	policy  Policy
+	members []*Breaker
+}
+
+// NewComposite constructs a new Composite over the given breakers.
+func NewComposite(policy Policy, members ...*Breaker) *Composite {
+	return &Composite{
+		policy:  policy,
+		members: members,
+	}
+}
+
+// Run will either return immediately with an error if the policy does not allow
+// the function to run (the error returned by the first member that rejected it),
+// or it will run the given function and pass along its return value. Each member
+// the result counts against classifies it with its own classifier, counts the call
+// as in flight while it runs, and is passed any panic if it was built with
+// WithPanicCapture. It is safe to call Run concurrently on the same Composite.
+func (c *Composite) Run(work func() error) error {
+	if work == nil {
+		return ErrNilFunc
+	}
+
+	admitted, chosen, err := c.admit()
+	if err != nil {
+		return err
+	}
+
+	capture := func(value interface{}, stack []byte) {
+		for _, i := range chosen {
+			if onPanic := c.members[i].onPanic; onPanic != nil {
+				onPanic(value, stack)
+			}
+		}
+	}
+	for _, i := range chosen {
+		c.members[i].enter()
+	}
+	result, panicValue := protect(capture, work)
+	for _, i := range chosen {
+		c.members[i].exit()
+	}
+
+	for _, i := range chosen {
+		m := c.members[i]
+		outcome := Failure
+		if panicValue == nil {
+			outcome = m.classifyResult(result)
+		}
+		m.finish(admitted[i], outcome, result)
+	}
+
+	if panicValue != nil {
+		panic(panicValue)
+	}
+
+	return result
+}
+
+// admit returns the admission from each member that admitted the call, and the
+// indexes of the members the result should count against.
+func (c *Composite) admit() ([]admission, []int, error) {
+	if len(c.members) == 0 {
+		return nil, nil, ErrBreakerOpen
+	}
+	admitted := make([]admission, len(c.members))
+
+	switch c.policy {
+	case Any:
+		var first error
+		for i, m := range c.members {
+			adm, err := m.admit()
+			if err == nil {
+				admitted[i] = adm
+				return admitted, []int{i}, nil
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		return nil, nil, first
+	default:
+		chosen := make([]int, 0, len(c.members))
+		for i, m := range c.members {
+			adm, err := m.admit()
+			if err != nil {
+				// give back any probe slots we already took
+				for _, j := range chosen {
+					c.members[j].finish(admitted[j], Ignore, nil)
+				}
+				return nil, nil, err
+			}
+			admitted[i] = adm
+			chosen = append(chosen, i)
+		}
+		return admitted, chosen, nil
+	}
+}
@@ -0,0 +1,114 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompositeAll(t *testing.T) {
+	a := NewWithOptions(2, 1, 1*time.Hour, WithHalfOpenProbes(1))
+	b := New(2, 1, 1*time.Hour)
+	c := NewComposite(All, a, b)
+
+	// failures count against every member
+	if err := c.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if a.Stats().Errors != 1 || b.Stats().Errors != 1 {
+		t.Error("failure not counted against every member")
+	}
+
+	// one open member is enough to short-circuit
+	b.Trip()
+	if err := c.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	// and a rejection gives back the probe slots of earlier members
+	a.Trip()
+	a.fireRecovery()
+	if err := c.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if !a.AllowProbe() {
+		t.Error("probe slot not released")
+	}
+	a.MarkProbeResult(nil)
+
+	b.Reset()
+	if err := c.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompositeAny(t *testing.T) {
+	a := New(1, 1, 1*time.Hour)
+	b := New(1, 1, 1*time.Hour)
+	c := NewComposite(Any, a, b)
+
+	// the result only counts against the member that admitted the call
+	if err := c.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if a.State() != Open || b.State() != Closed {
+		t.Error(a.State(), b.State())
+	}
+
+	// which falls through to the next member once the first is open
+	if err := c.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if b.State() != Open {
+		t.Error(b.State())
+	}
+
+	if err := c.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if err := NewComposite(Any).Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if err := NewComposite(All).Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+}
+
+func TestCompositePanics(t *testing.T) {
+	captured := 0
+	a := NewWithOptions(1, 1, 1*time.Hour, WithPanicCapture(func(interface{}, []byte) { captured++ }))
+	b := New(1, 1, 1*time.Hour)
+	c := NewComposite(All, a, b)
+
+	func() {
+		defer func() {
+			if val := recover(); val != "foo" {
+				t.Error("incorrect panic", val)
+			}
+		}()
+		_ = c.Run(alwaysPanics)
+	}()
+
+	if a.State() != Open || b.State() != Open {
+		t.Error("panic not counted against every member")
+	}
+	if captured != 1 {
+		t.Error("panic captured", captured, "times")
+	}
+}
+
+func TestCompositeInFlight(t *testing.T) {
+	a := New(1, 1, 1*time.Hour)
+	b := New(1, 1, 1*time.Hour)
+
+	// only the member the call counts against sees it running
+	_ = NewComposite(Any, a, b).Run(func() error {
+		if a.Stats().InFlight != 1 || b.Stats().InFlight != 0 {
+			t.Error(a.Stats().InFlight, b.Stats().InFlight)
+		}
+		return nil
+	})
+	_ = NewComposite(All, a, b).Run(returnsSuccess)
+	if sa, sb := a.Stats(), b.Stats(); sa.InFlight != 0 || sa.PeakInFlight != 1 || sb.PeakInFlight != 1 {
+		t.Errorf("%+v %+v", sa, sb)
+	}
+}
@@ -0,0 +1,17 @@
+package breaker
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the given breaker, for middleware that
+// picks a breaker (e.g. by route) and makes it available further down the chain.
+func NewContext(ctx context.Context, b *Breaker) context.Context {
+	return context.WithValue(ctx, contextKey{}, b)
+}
+
+// FromContext returns the breaker stored in ctx by NewContext, if any.
+func FromContext(ctx context.Context) (*Breaker, bool) {
+	b, ok := ctx.Value(contextKey{}).(*Breaker)
+	return b, ok && b != nil
+}
@@ -0,0 +1,34 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestContext(t *testing.T) {
+	b := New(1, 1, 1*time.Second)
+	ctx := NewContext(context.Background(), b)
+
+	if got, ok := FromContext(ctx); !ok || got != b {
+		t.Error(got, ok)
+	}
+
+	// still there in derived contexts
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if got, ok := FromContext(child); !ok || got != b {
+		t.Error(got, ok)
+	}
+}
+
+func TestContextMissing(t *testing.T) {
+	if got, ok := FromContext(context.Background()); ok || got != nil {
+		t.Error(got, ok)
+	}
+
+	// a nil breaker is treated as missing
+	if got, ok := FromContext(NewContext(context.Background(), nil)); ok || got != nil {
+		t.Error(got, ok)
+	}
+}
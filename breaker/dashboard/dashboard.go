@@ -0,0 +1,183 @@
+// Package dashboard serves a simple web page for inspecting and controlling the
+// circuit-breakers in a breaker.Registry.
+package dashboard
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/etherlabsio/resiliency/breaker"
+)
+
+// Option configures optional behaviour of a Handler.
+type Option func(*handler)
+
+// WithAuthorizer makes the handler call the given function for each request that
+// would reset or trip a breaker, rejecting the request with 403 Forbidden unless it
+// returns true. Without this option anyone who can reach the handler may do so.
+//
+// The handler has no CSRF protection of its own: a page on any other site can make
+// a visitor's browser POST to it. Without an authorizer that checks something such
+// a page cannot supply (a token in a custom header, say, rather than a cookie alone)
+// the handler must not be reachable from browsers that visit untrusted sites.
+func WithAuthorizer(allow func(*http.Request) bool) Option {
+	return func(h *handler) {
+		h.allow = allow
+	}
+}
+
+// Handler returns an http.Handler listing every breaker in the registry along with
+// its state, counters and time until recovery, with buttons to reset or trip each
+// one. Requests that accept "application/json" get the list as JSON instead. A
+// breaker is reset or tripped by POSTing "name" and "action" ("reset" or "trip")
+// form values to the same URL.
+func Handler(reg *breaker.Registry, opts ...Option) http.Handler {
+	h := &handler{reg: reg}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+type handler struct {
+	reg   *breaker.Registry
+	allow func(*http.Request) bool
+}
+
+type status struct {
+	Name       string        `json:"name"`
+	State      string        `json:"state"`
+	Errors     int           `json:"errors"`
+	Successes  float64       `json:"successes"`
+	RecoversIn time.Duration `json:"-"`
+	Seconds    float64       `json:"recovers_in_seconds"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.list(w, r)
+	case http.MethodPost:
+		h.act(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	names := h.reg.Names()
+	statuses := make([]status, 0, len(names))
+
+	for _, name := range names {
+		b := h.reg.Get(name)
+		if b == nil {
+			// unregistered since we listed the names
+			continue
+		}
+
+		stats := b.Stats()
+		s := status{
+			Name:      name,
+			State:     stats.State.String(),
+			Errors:    stats.Errors,
+			Successes: stats.Successes,
+		}
+		s.RecoversIn = stats.RecoversIn
+		s.Seconds = stats.RecoversIn.Seconds()
+		statuses = append(statuses, s)
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(statuses)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = page.Execute(w, statuses)
+}
+
+func (h *handler) act(w http.ResponseWriter, r *http.Request) {
+	if h.allow != nil && !h.allow(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	b := h.reg.Get(r.FormValue("name"))
+	if b == nil {
+		http.Error(w, "no such circuit breaker", http.StatusNotFound)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "reset":
+		b.Reset()
+	case "trip":
+		b.Trip()
+	default:
+		http.Error(w, "action must be reset or trip", http.StatusBadRequest)
+		return
+	}
+
+	if wantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// back to the listing, which is served from this same URL
+	w.Header().Set("Location", listing(r))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// listing returns the URL of the page that r was posted to, relative to itself.
+// This is not resolved against r.URL as http.Redirect would, since the handler may
+// be mounted under a prefix that has been stripped (by http.StripPrefix or a
+// proxy), leaving r.URL.Path without it; relative to itself only the last segment
+// of the path matters, and that survives.
+func listing(r *http.Request) string {
+	p := r.URL.EscapedPath()
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		p = u.EscapedPath()
+	}
+	if p == "" || strings.HasSuffix(p, "/") {
+		return "./"
+	}
+	return "./" + path.Base(p)
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+var page = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"round": func(d time.Duration) time.Duration { return d.Round(time.Second) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Circuit breakers</title></head>
+<body>
+<h1>Circuit breakers</h1>
+<table>
+<tr><th>Name</th><th>State</th><th>Errors</th><th>Successes</th><th>Recovers in</th><th></th></tr>
+{{range .}}<tr>
+<td>{{.Name}}</td>
+<td>{{.State}}</td>
+<td>{{.Errors}}</td>
+<td>{{.Successes}}</td>
+<td>{{if .RecoversIn}}{{round .RecoversIn}}{{end}}</td>
+<td>
+<form method="post"><input type="hidden" name="name" value="{{.Name}}"><button name="action" value="reset">Reset</button><button name="action" value="trip">Trip</button></form>
+</td>
+</tr>
+{{else}}<tr><td colspan="6">No circuit breakers registered.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
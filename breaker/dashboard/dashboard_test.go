@@ -0,0 +1,133 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/resiliency/breaker"
+)
+
+func newRegistry(t *testing.T) (*breaker.Registry, *breaker.Breaker) {
+	reg := breaker.NewRegistry()
+	b := breaker.New(3, 1, 1*time.Minute)
+	if err := reg.Register("db", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register("cache", breaker.New(3, 1, 1*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	return reg, b
+}
+
+func post(h http.Handler, target, name, action string, header http.Header) *httptest.ResponseRecorder {
+	form := url.Values{"name": {name}, "action": {action}}
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerJSON(t *testing.T) {
+	reg, b := newRegistry(t)
+	b.Trip()
+
+	req := httptest.NewRequest(http.MethodGet, "/breakers", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatal(rec.Code)
+	}
+	var statuses []status
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].Name != "cache" || statuses[1].Name != "db" {
+		t.Fatalf("%+v", statuses)
+	}
+	if statuses[0].State != "closed" || statuses[0].Seconds != 0 {
+		t.Errorf("%+v", statuses[0])
+	}
+	if statuses[1].State != "open" || statuses[1].Seconds <= 0 || statuses[1].Seconds > 60 {
+		t.Errorf("%+v", statuses[1])
+	}
+}
+
+func TestHandlerHTML(t *testing.T) {
+	reg, b := newRegistry(t)
+	b.Trip()
+
+	rec := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/breakers", nil))
+
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"<td>db</td>", "<td>open</td>", `value="trip"`, `value="reset"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page missing %q", want)
+		}
+	}
+}
+
+func TestHandlerActions(t *testing.T) {
+	reg, b := newRegistry(t)
+	h := Handler(reg, WithAuthorizer(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "yes"
+	}))
+	auth := http.Header{"Authorization": {"yes"}}
+
+	if rec := post(h, "/breakers", "db", "trip", nil); rec.Code != http.StatusForbidden {
+		t.Error(rec.Code)
+	}
+	if b.State() != breaker.Closed {
+		t.Error("unauthorised trip took effect")
+	}
+
+	if rec := post(h, "/breakers", "db", "trip", auth); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "./breakers" {
+		t.Error(rec.Code, rec.Header())
+	}
+
+	// the redirect still leads back to the listing under a stripped prefix
+	for target, want := range map[string]string{"/admin/breakers": "./breakers", "/admin/": "./"} {
+		rec := post(http.StripPrefix("/admin", h), target, "db", "trip", auth)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+			t.Error(target, rec.Code, rec.Header())
+		}
+	}
+	if b.State() != breaker.Open {
+		t.Error("trip did not take effect")
+	}
+
+	auth.Set("Accept", "application/json")
+	if rec := post(h, "/breakers", "db", "reset", auth); rec.Code != http.StatusNoContent {
+		t.Error(rec.Code)
+	}
+	if b.State() != breaker.Closed {
+		t.Error("reset did not take effect")
+	}
+
+	if rec := post(h, "/breakers", "nope", "reset", auth); rec.Code != http.StatusNotFound {
+		t.Error(rec.Code)
+	}
+	if rec := post(h, "/breakers", "db", "explode", auth); rec.Code != http.StatusBadRequest {
+		t.Error(rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/breakers", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error(rec.Code)
+	}
+}
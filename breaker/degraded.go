@@ -0,0 +1,88 @@
+package breaker
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLoadShed is the error returned from Run() when the function is not executed
+// because the breaker is degraded and the call was one of those chosen to be shed
+// (see WithDegradedState). It is not equal to ErrBreakerOpen, but
+// errors.Is(ErrLoadShed, ErrBreakerOpen) is true.
+var ErrLoadShed error = loadShedError{}
+
+type loadShedError struct{}
+
+func (loadShedError) Error() string {
+	return "circuit breaker is degraded and shed the call"
+}
+
+func (loadShedError) Is(target error) bool {
+	return target == ErrBreakerOpen
+}
+
+// WithDegradedState adds a degraded state between closed and open. A closed breaker
+// becomes degraded once "threshold" errors are seen within the error window (which
+// should be fewer than its error threshold), and while degraded it rejects a random
+// "shedFraction" of calls with ErrLoadShed, easing the load on the dependency. The
+// calls it does admit carry on counting in the same window: if the errors reach the
+// error threshold the breaker opens as usual, and once the window passes without an
+// error it closes again. A shed fraction outside 0 to 1 is clamped to that range,
+// and NaN means 0. This has no effect on a breaker using WithErrorBudget.
+func WithDegradedState(threshold int, shedFraction float64) Option {
+	if !(shedFraction > 0) {
+		shedFraction = 0
+	} else if shedFraction > 1 {
+		shedFraction = 1
+	}
+	return func(b *Breaker) {
+		b.degradeAt = threshold
+		b.shedFraction = shedFraction
+		b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+}
+
+// admitDegraded admits or sheds a call that found the breaker degraded. Like
+// admitHalfOpen it takes the lock, both for the random source and so that a
+// breaker whose errors have subsided can close.
+func (b *Breaker) admitDegraded() (admission, error) {
+	b.lock.Lock()
+
+	if b.state == degraded && b.errorsExpired() {
+		b.shiftTier(closed, CauseErrorsSubsided)
+	}
+	if b.state != degraded {
+		// it has moved on since we loaded the state without the lock
+		b.lock.Unlock()
+		return b.tryAdmit()
+	}
+	defer b.lock.Unlock()
+
+	adm := admission{state: b.state, epoch: b.epoch}
+	if b.rand.Float64() < b.shedFraction {
+		if b.shadowed() {
+			b.shadowShed++
+		} else {
+			b.shed++
+		}
+		return adm, ErrLoadShed
+	}
+	return adm, nil
+}
+
+// shiftTier moves the breaker between closed and degraded. Unlike changeState this
+// keeps the counters and the epoch, since the two states share one error window
+// and every call admitted in either counts towards it.
+func (b *Breaker) shiftTier(newState uint32, cause Cause) {
+	if newState == b.state {
+		return
+	}
+	if newState == closed && b.errorsExpired() {
+		b.clearErrors()
+	}
+	b.transition.cause = cause
+	b.transition.at = b.clock.Now()
+	b.emitStateChange(newState)
+	atomic.StoreUint32(&b.state, newState)
+}
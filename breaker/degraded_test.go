@@ -0,0 +1,97 @@
+package breaker
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBreakerDegradedEscalates(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(3, 1, 1*time.Second, WithClock(clock), WithDegradedState(2, 0))
+
+	_ = breaker.Run(returnsError)
+	if breaker.State() != Closed {
+		t.Fatal(breaker.State())
+	}
+	_ = breaker.Run(returnsError)
+	if stats := breaker.Stats(); stats.State != Degraded || stats.Errors != 2 || stats.LastCause != CauseThreshold {
+		t.Fatalf("%+v", stats)
+	}
+
+	// with nothing to shed calls still run, and keep counting in the same window
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.State() != Open {
+		t.Fatal(breaker.State())
+	}
+}
+
+func TestBreakerDegradedSubsides(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(3, 1, 1*time.Second, WithClock(clock), WithDegradedState(1, 1))
+
+	_ = breaker.Run(returnsError)
+	if breaker.State() != Degraded {
+		t.Fatal(breaker.State())
+	}
+
+	// everything is shed...
+	for i := 0; i < 3; i++ {
+		err := breaker.Run(returnsSuccess)
+		if err != ErrLoadShed || !errors.Is(err, ErrBreakerOpen) {
+			t.Error(err)
+		}
+	}
+	if stats := breaker.Stats(); stats.Shed != 3 {
+		t.Errorf("%+v", stats)
+	}
+
+	// ...until the window passes without errors
+	clock.Advance(2 * time.Second)
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); stats.State != Closed || stats.Errors != 0 || stats.LastCause != CauseErrorsSubsided {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerDegradedWindowRestarts(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(5, 1, 1*time.Second, WithClock(clock), WithDegradedState(2, 0))
+
+	_ = breaker.Run(returnsError)
+	_ = breaker.Run(returnsError)
+	if breaker.State() != Degraded {
+		t.Fatal(breaker.State())
+	}
+
+	// a single error in a fresh window is below the degraded threshold
+	clock.Advance(2 * time.Second)
+	_ = breaker.Run(returnsError)
+	if stats := breaker.Stats(); stats.State != Closed || stats.Errors != 1 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerDegradedShedFractionBounds(t *testing.T) {
+	for _, tc := range []struct {
+		fraction, ratio float64
+	}{
+		{-1, 1},
+		{2, 0},
+		{math.NaN(), 1},
+	} {
+		breaker := NewWithOptions(3, 1, 1*time.Hour, WithDegradedState(1, tc.fraction))
+		_ = breaker.Run(returnsError)
+		if ratio := breaker.AdmitRatio(); breaker.State() != Degraded || ratio != tc.ratio {
+			t.Error(tc.fraction, breaker.State(), ratio)
+		}
+	}
+}
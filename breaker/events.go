@@ -0,0 +1,218 @@
+package breaker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind says what an Event reports.
+type EventKind int
+
+const (
+	// EventStateChange reports the breaker moving from one state to another. A trip
+	// is a state change whose To is Open.
+	EventStateChange EventKind = iota
+	// EventRejected reports a call that was not run because of the breaker's state
+	// (because it was open, at its probe limit or shedding load).
+	EventRejected
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStateChange:
+		return "state change"
+	case EventRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// Event is something that happened to a Breaker, as delivered by Events.
+type Event struct {
+	Time time.Time
+	Kind EventKind
+
+	// For a state change, the states before and after and why it happened. For a
+	// rejection both states are the state that rejected the call.
+	From, To State
+	Cause    Cause
+
+	// For a state change, the counters as they stood when it happened, including
+	// the call that caused it.
+	Errors    int
+	Successes float64
+
+	// For a rejection, the error the call was rejected with.
+	Err error
+
+	// Whether the breaker was in shadow mode (see SetShadowMode), so that a trip
+	// or rejection is only what would have happened.
+	Shadow bool
+}
+
+// EventBuffer is the capacity of each channel returned by Events.
+const EventBuffer = 64
+
+type subscribers struct {
+	count int32 // atomic, so that emitting costs nothing with no subscribers
+
+	sync.Mutex
+	chans   []chan Event
+	dropped int
+}
+
+// Events returns a new channel on which the breaker delivers an Event for every
+// state change and every rejected call, for callers that would rather consume
+// them in their own goroutine than register callbacks. Each call returns a
+// separate subscription with room for EventBuffer events. The breaker never waits
+// for a subscriber: an event that arrives when a channel is full is dropped for
+// that subscriber (so it always sees the oldest events it has not yet received),
+// and counted in Stats.DroppedEvents. Call Unsubscribe once done with the channel.
+func (b *Breaker) Events() <-chan Event {
+	ch := make(chan Event, EventBuffer)
+
+	b.subs.Lock()
+	defer b.subs.Unlock()
+
+	b.subs.chans = append(b.subs.chans, ch)
+	atomic.AddInt32(&b.subs.count, 1)
+	return ch
+}
+
+// Unsubscribe stops the delivery of events on a channel returned by Events, and
+// closes it. Events already buffered can still be received. Unsubscribing a
+// channel that is not subscribed does nothing.
+func (b *Breaker) Unsubscribe(events <-chan Event) {
+	b.subs.Lock()
+	defer b.subs.Unlock()
+
+	for i, ch := range b.subs.chans {
+		if ch == events {
+			b.subs.chans = append(b.subs.chans[:i], b.subs.chans[i+1:]...)
+			atomic.AddInt32(&b.subs.count, -1)
+			close(ch)
+			return
+		}
+	}
+}
+
+// emit delivers an event to every subscriber. It may be called with the breaker's
+// lock held, since it never blocks on a subscriber and the subscriber lock is
+// never held while taking the breaker's.
+func (b *Breaker) emit(event Event) {
+	if atomic.LoadInt32(&b.subs.count) == 0 {
+		return
+	}
+
+	b.subs.Lock()
+	defer b.subs.Unlock()
+
+	for _, ch := range b.subs.chans {
+		select {
+		case ch <- event:
+		default:
+			b.subs.dropped++
+		}
+	}
+}
+
+// emitStateChange reports a transition about to be made, with the lock held and
+// the transition's cause and time already recorded.
+func (b *Breaker) emitStateChange(newState uint32) {
+	event := Event{
+		Time:      b.transition.at,
+		Kind:      EventStateChange,
+		From:      State(b.state),
+		To:        State(newState),
+		Cause:     b.transition.cause,
+		Errors:    b.errors,
+		Successes: b.successes,
+		Shadow:    b.shadowed(),
+	}
+	b.history.add(event)
+	b.emit(event)
+}
+
+func (b *Breaker) emitRejected(state uint32, err error, shadow bool) {
+	if atomic.LoadInt32(&b.subs.count) == 0 {
+		return
+	}
+	b.emit(Event{
+		Time:   b.clock.Now(),
+		Kind:   EventRejected,
+		From:   State(state),
+		To:     State(state),
+		Err:    err,
+		Shadow: shadow,
+	})
+}
+
+// DefaultHistorySize is the number of state changes a Breaker keeps for History,
+// unless configured otherwise with WithHistorySize.
+const DefaultHistorySize = 32
+
+// WithHistorySize sets how many of its most recent state changes the breaker keeps
+// for History. A size of zero or less keeps none.
+func WithHistorySize(n int) Option {
+	return func(b *Breaker) {
+		if n <= 0 {
+			n = -1
+		}
+		b.history.size = n
+	}
+}
+
+// History returns the breaker's most recent state changes, oldest first, as they
+// were delivered by Events: by default the last DefaultHistorySize of them (see
+// WithHistorySize). This is always on, so that the lead-up to a problem can be
+// inspected once it is noticed. Rejected calls are not kept.
+func (b *Breaker) History() []Event {
+	b.lazyInit()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	h := &b.history
+	events := make([]Event, 0, len(h.events))
+	if len(h.events) == cap(h.events) {
+		events = append(events, h.events[h.next:]...)
+	}
+	return append(events, h.events[:h.next]...)
+}
+
+// eventRing keeps the most recent events up to its size, guarded by the breaker's
+// lock. Its buffer is only allocated once there is something to keep.
+type eventRing struct {
+	size   int // zero means DefaultHistorySize, negative none
+	events []Event
+	next   int // once full, the index of the oldest event
+}
+
+func (r *eventRing) add(event Event) {
+	if r.size < 0 {
+		return
+	}
+	if r.events == nil {
+		size := r.size
+		if size == 0 {
+			size = DefaultHistorySize
+		}
+		r.events = make([]Event, 0, size)
+	}
+
+	if len(r.events) < cap(r.events) {
+		r.events = append(r.events, event)
+		r.next = len(r.events) % cap(r.events)
+		return
+	}
+	r.events[r.next] = event
+	r.next = (r.next + 1) % cap(r.events)
+}
+
+func (b *Breaker) droppedEvents() int {
+	b.subs.Lock()
+	defer b.subs.Unlock()
+
+	return b.subs.dropped
+}
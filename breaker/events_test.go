@@ -0,0 +1,129 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerEvents(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(2, 1, 1*time.Second, WithClock(clock))
+	events := breaker.Events()
+
+	_ = breaker.Run(returnsError)
+	_ = breaker.Run(returnsError)
+	_ = breaker.Run(returnsSuccess)
+	clock.Advance(1 * time.Second)
+
+	for _, want := range []Event{
+		{Kind: EventStateChange, From: Closed, To: Open, Cause: CauseThreshold, Errors: 2},
+		{Kind: EventRejected, From: Open, To: Open, Err: ErrBreakerOpen},
+		{Kind: EventStateChange, From: Open, To: HalfOpen, Cause: CauseTimer},
+	} {
+		select {
+		case got := <-events:
+			want.Time = got.Time
+			if got != want {
+				t.Errorf("want %+v, got %+v", want, got)
+			}
+		default:
+			t.Fatalf("missing %+v", want)
+		}
+	}
+	select {
+	case got := <-events:
+		t.Errorf("unexpected %+v", got)
+	default:
+	}
+
+	breaker.Unsubscribe(events)
+	if _, ok := <-events; ok {
+		t.Error("channel not closed")
+	}
+	breaker.Unsubscribe(events)
+}
+
+func TestBreakerEventsSlowConsumer(t *testing.T) {
+	breaker := New(1, 1, 1*time.Hour)
+	slow := breaker.Events()
+	fast := breaker.Events()
+	defer breaker.Unsubscribe(fast)
+
+	breaker.Trip()
+	for i := 0; i < EventBuffer+10; i++ {
+		_ = breaker.Run(returnsSuccess)
+		<-fast
+	}
+
+	// the breaker never blocked; the slow subscriber has the oldest events
+	if got := <-slow; got.Kind != EventStateChange {
+		t.Errorf("%+v", got)
+	}
+	if dropped := breaker.Stats().DroppedEvents; dropped != 11 {
+		t.Error(dropped)
+	}
+	breaker.Unsubscribe(slow)
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != EventBuffer-1 {
+		t.Error(n)
+	}
+}
+
+func TestBreakerHistory(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(clock), WithHistorySize(3))
+
+	if h := breaker.History(); len(h) != 0 {
+		t.Error(h)
+	}
+
+	_ = breaker.Run(returnsError)
+	_ = breaker.Run(returnsSuccess) // rejections are not kept
+	if h := breaker.History(); len(h) != 1 || h[0].To != Open || h[0].Cause != CauseThreshold {
+		t.Errorf("%+v", h)
+	}
+
+	// the oldest are overwritten
+	clock.Advance(1 * time.Second)
+	_ = breaker.Run(returnsSuccess)
+	breaker.Trip()
+	h := breaker.History()
+	if len(h) != 3 {
+		t.Fatalf("%+v", h)
+	}
+	for i, want := range []State{HalfOpen, Closed, Open} {
+		if h[i].To != want {
+			t.Errorf("%d: %+v", i, h[i])
+		}
+	}
+	if !h[0].Time.Equal(time.Unix(1, 0)) {
+		t.Error(h[0].Time)
+	}
+
+	// and callers can't modify it
+	h[0].To = Pending
+	if breaker.History()[0].To != HalfOpen {
+		t.Error("history shared")
+	}
+}
+
+func TestBreakerHistoryDefaultSize(t *testing.T) {
+	breaker := New(1, 1, 1*time.Hour)
+	for i := 0; i < DefaultHistorySize; i++ {
+		breaker.Trip()
+		breaker.Reset()
+	}
+	h := breaker.History()
+	if len(h) != DefaultHistorySize || h[0].To != Open || h[len(h)-1].To != Closed {
+		t.Errorf("%d events, %+v first", len(h), h[0])
+	}
+
+	disabled := NewWithOptions(1, 1, 1*time.Hour, WithHistorySize(0))
+	disabled.Trip()
+	if h := disabled.History(); len(h) != 0 {
+		t.Error(h)
+	}
+}
@@ -0,0 +1,114 @@
+//go:build go1.18
+// +build go1.18
+
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+// FuzzBreakerStateMachine drives a breaker through an arbitrary sequence of
+// results, overlapping calls and clock movements, checking its invariants
+// after every step and that it can always be brought back to closed.
+func FuzzBreakerStateMachine(f *testing.F) {
+	f.Add([]byte{3, 2, 1, 1, 1, 1, 2, 200, 3, 0, 4, 1})
+	f.Add([]byte{0, 0, 0, 1, 1, 2, 255, 2, 255, 0})
+	f.Add([]byte{2, 3, 2, 1, 1, 2, 250, 3, 3, 3, 1, 4, 0, 4, 1, 2, 250, 3, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < 3 {
+			return
+		}
+
+		const timeout = 100 * time.Millisecond
+		clock := newTestClock()
+		opts := []Option{WithClock(clock), WithHalfOpenProbes(int(data[2] % 3))}
+		if data[2]&8 != 0 {
+			opts = append(opts, WithStartupGate())
+		}
+		if data[2]&16 != 0 {
+			// shedding everything keeps the run deterministic
+			opts = append(opts, WithDegradedState(1, 1))
+		}
+		b := NewWithOptions(int(data[0]%4), int(data[1]%4), timeout, opts...)
+		data = data[3:]
+
+		var inFlight []admission
+		finish := func(fail bool) {
+			adm := inFlight[0]
+			inFlight = inFlight[1:]
+			if fail {
+				b.processResult(adm, Failure, 1)
+			} else {
+				b.processResult(adm, Success, 1)
+			}
+		}
+
+		for i := 0; i < len(data); i++ {
+			switch data[i] % 5 {
+			case 0:
+				_ = b.Run(returnsSuccess)
+			case 1:
+				_ = b.Run(returnsError)
+			case 2:
+				if i+1 < len(data) {
+					i++
+					clock.Advance(time.Duration(data[i]) * time.Millisecond)
+				}
+			case 3:
+				if adm, err := b.admit(); err == nil {
+					inFlight = append(inFlight, adm)
+				}
+			case 4:
+				if len(inFlight) > 0 {
+					finish(data[i]&8 != 0)
+				}
+			}
+			checkInvariants(t, b, clock)
+		}
+
+		for len(inFlight) > 0 {
+			finish(false)
+			checkInvariants(t, b, clock)
+		}
+
+		// given enough time and successes the breaker must recover
+		for i := 0; i < 10 && b.state != closed; i++ {
+			clock.Advance(timeout)
+			_ = b.Run(returnsSuccess)
+			checkInvariants(t, b, clock)
+		}
+		if b.state != closed {
+			t.Fatalf("breaker did not recover, state %d", b.state)
+		}
+	})
+}
+
+func checkInvariants(t *testing.T, b *Breaker, clock *testClock) {
+	t.Helper()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case closed, open, halfOpen, pending, degraded:
+	default:
+		t.Fatalf("invalid state %d", b.state)
+	}
+	if b.errors < 0 || b.successes < 0 || b.probes < 0 {
+		t.Fatalf("negative counter: errors %d, successes %v, probes %d", b.errors, b.successes, b.probes)
+	}
+	if b.maxProbes > 0 && b.probes > b.maxProbes {
+		t.Fatalf("%d probes in flight, limit %d", b.probes, b.maxProbes)
+	}
+	if b.state != halfOpen && b.probes != 0 {
+		t.Fatalf("%d probes in flight while not half-open", b.probes)
+	}
+	if pending := clock.Pending(); pending > 1 {
+		t.Fatalf("%d recovery timers scheduled", pending)
+	}
+	if (b.state == open && !b.manual) != (b.recovery != nil) {
+		t.Fatalf("state %d with recovery timer %v", b.state, b.recovery)
+	}
+}
@@ -0,0 +1,113 @@
+package breaker
+
+import (
+	"context"
+	"time"
+)
+
+// WithCancellationGrace gives functions run with RunContext a grace period in which
+// to finish cleanly (for example to flush a write) once the caller's context is
+// done. The function is passed a context that carries the same values but is only
+// done "grace" after the caller's context is, as timed by the breaker's Clock, with
+// the same error; its deadline likewise is the caller's plus the grace period. If the
+// function returns within that time its result is classified as usual, rather than
+// as a cancellation or timeout, and returned from RunContext.
+//
+// To allow this the function runs in its own goroutine. If it is still running
+// when the grace period ends RunContext gives up on it, returning the error from
+// the caller's context, and the call counts as a cancellation or timeout exactly as
+// it would without this option. The goroutine however carries on until the function
+// returns, so a function that does not honour its context will leak it; whatever
+// it eventually returns is discarded, and a panic by then is recovered (and passed
+// to the function given to WithPanicCapture, if any) rather than re-raised.
+func WithCancellationGrace(grace time.Duration) Option {
+	return func(b *Breaker) {
+		b.grace = grace
+	}
+}
+
+// runWithGrace runs work as described by WithCancellationGrace, reporting whether
+// it had to give up on it.
+func (b *Breaker) runWithGrace(ctx context.Context, work func(context.Context) error) (result error, panicValue interface{}, abandoned bool) {
+	inner, cancel := newGraceContext(ctx, b.grace)
+	defer cancel() // which ends the grace period, however RunContext returns
+
+	type outcome struct {
+		result     error
+		panicValue interface{}
+	}
+	finished := make(chan outcome, 1) // so that an abandoned goroutine can still exit
+	go func() {
+		result, panicValue := protect(b.onPanic, func() error {
+			return work(inner)
+		})
+		finished <- outcome{result, panicValue}
+	}()
+
+	select {
+	case o := <-finished:
+		return o.result, o.panicValue, false
+	case <-ctx.Done():
+	}
+
+	expired := make(chan struct{})
+	timer := b.clock.AfterFunc(b.grace, func() {
+		close(expired)
+	})
+	defer timer.Stop()
+
+	select {
+	case o := <-finished:
+		return o.result, o.panicValue, false
+	case <-expired:
+		return ctx.Err(), nil, true
+	}
+}
+
+// graceContext has the values of its parent, and reports its parent's deadline (if
+// it has one) plus grace, but is only done once cancelled. runWithGrace times the
+// grace period itself, with the breaker's Clock, so that there is only one time
+// source; a deadline of its own would be on the system clock.
+type graceContext struct {
+	context.Context // cancelled by runWithGrace
+	parent          context.Context
+	grace           time.Duration
+}
+
+func newGraceContext(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	inner, cancel := context.WithCancel(detachedContext{parent})
+	return graceContext{inner, parent, grace}, cancel
+}
+
+func (c graceContext) Deadline() (time.Time, bool) {
+	deadline, ok := c.parent.Deadline()
+	if !ok {
+		return deadline, false
+	}
+	return deadline.Add(c.grace), true
+}
+
+// Err is the parent's error once the grace period is over, so that a function can
+// still tell a deadline from a cancellation.
+func (c graceContext) Err() error {
+	if c.Context.Err() == nil {
+		return nil
+	}
+	if err := c.parent.Err(); err != nil {
+		return err
+	}
+	return context.Canceled
+}
+
+// detachedContext carries the values of its parent but none of its cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,104 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBreakerCancellationGrace(t *testing.T) {
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithCancellationGrace(1*time.Second))
+
+	type key struct{}
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	started := make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	// a function that finishes within the grace period has its result classified
+	err := breaker.RunContext(parent, func(ctx context.Context) error {
+		close(started)
+		<-parent.Done()
+		if ctx.Err() != nil || ctx.Value(key{}) != "value" {
+			t.Error("grace context", ctx.Err(), ctx.Value(key{}))
+		}
+		return errSomeError
+	})
+	if err != errSomeError || breaker.State() != Open {
+		t.Error(err, breaker.State())
+	}
+	if stats := breaker.Stats(); stats.CanceledLatency.Count != 0 || stats.FailureLatency.Count != 1 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerCancellationGraceExpires(t *testing.T) {
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithCancellationGrace(10*time.Millisecond))
+
+	parent, cancel := context.WithCancel(context.Background())
+	cancel()
+	release := make(chan struct{})
+	defer close(release)
+	inner := make(chan context.Context, 1)
+
+	// one that doesn't is abandoned, and counts as cancelled
+	err := breaker.RunContext(parent, func(ctx context.Context) error {
+		inner <- ctx
+		<-release
+		return errSomeError
+	})
+	if err != context.Canceled || breaker.State() != Closed {
+		t.Error(err, breaker.State())
+	}
+	if stats := breaker.Stats(); stats.CanceledLatency.Count != 1 {
+		t.Errorf("%+v", stats)
+	}
+	if ctx := <-inner; ctx.Err() != context.Canceled {
+		t.Error("context not cancelled after the grace period", ctx.Err())
+	}
+}
+
+func TestBreakerCancellationGraceClock(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithClock(clock), WithCancellationGrace(10*time.Millisecond))
+
+	parent, cancel := context.WithDeadline(context.Background(), time.Now().Add(-1*time.Second))
+	defer cancel()
+	want, _ := parent.Deadline()
+
+	// the grace period is on the breaker's clock, so the wall clock passing it
+	// changes nothing
+	err := breaker.RunContext(parent, func(ctx context.Context) error {
+		if deadline, _ := ctx.Deadline(); !deadline.Equal(want.Add(10 * time.Millisecond)) {
+			t.Error("grace deadline", deadline)
+		}
+		select {
+		case <-ctx.Done():
+			t.Error("grace period ended by the wall clock")
+		case <-time.After(50 * time.Millisecond):
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// and once it is over by that clock the function sees the caller's error
+	go func() {
+		for clock.Pending() == 0 {
+			time.Sleep(1 * time.Millisecond)
+		}
+		clock.Advance(10 * time.Millisecond)
+	}()
+	inner := make(chan error, 1)
+	err = breaker.RunContext(parent, func(ctx context.Context) error {
+		<-ctx.Done()
+		inner <- ctx.Err()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded || <-inner != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,126 @@
+package breaker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrDuplicateName is the error returned by Registry.Register when a breaker is
+// already registered under the given name.
+var ErrDuplicateName = errors.New("a circuit breaker is already registered with that name")
+
+// ErrNilBreaker is the error returned by Registry.Register when the breaker is nil.
+var ErrNilBreaker = errors.New("cannot register a nil circuit breaker")
+
+// Registry is a set of named breakers, for code that needs to inspect or manage
+// many breakers at once (such as the dashboard subpackage). It is safe to use
+// concurrently.
+type Registry struct {
+	lock     sync.RWMutex
+	breakers map[string]*Breaker
+}
+
+// NewRegistry constructs a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Register adds the breaker to the registry under the given name, returning
+// ErrDuplicateName if the name is already taken, or ErrNilBreaker if b is nil.
+func (r *Registry) Register(name string, b *Breaker) error {
+	if b == nil {
+		return ErrNilBreaker
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.breakers[name]; ok {
+		return ErrDuplicateName
+	}
+	r.breakers[name] = b
+	return nil
+}
+
+// Unregister removes the named breaker from the registry, if present.
+func (r *Registry) Unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.breakers, name)
+}
+
+// Get returns the breaker registered under the given name, or nil if there is none.
+func (r *Registry) Get(name string) *Breaker {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.breakers[name]
+}
+
+// Names returns the names of all registered breakers, in sorted order.
+func (r *Registry) Names() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	names := make([]string, 0, len(r.breakers))
+	for name := range r.breakers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResetAll calls Reset on every registered breaker.
+func (r *Registry) ResetAll() {
+	for _, b := range r.snapshot() {
+		b.Reset()
+	}
+}
+
+// TripAll calls Trip on every registered breaker.
+func (r *Registry) TripAll() {
+	for _, b := range r.snapshot() {
+		b.Trip()
+	}
+}
+
+// snapshot returns the registered breakers by name, so that they can be acted on
+// without holding the registry's lock: a breaker's callbacks are then free to use
+// the registry themselves.
+func (r *Registry) snapshot() map[string]*Breaker {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	breakers := make(map[string]*Breaker, len(r.breakers))
+	for name, b := range r.breakers {
+		breakers[name] = b
+	}
+	return breakers
+}
+
+// AllHealthy reports whether every registered breaker is Healthy.
+func (r *Registry) AllHealthy() bool {
+	for _, b := range r.snapshot() {
+		if !b.Healthy() {
+			return false
+		}
+	}
+	return true
+}
+
+// Unhealthy returns the names of the registered breakers that are not Healthy, in
+// sorted order, for example to report which dependencies are down.
+func (r *Registry) Unhealthy() []string {
+	var names []string
+	for name, b := range r.snapshot() {
+		if !b.Healthy() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
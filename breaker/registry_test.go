@@ -0,0 +1,91 @@
+package breaker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	a := New(1, 1, 1*time.Second)
+	b := New(1, 1, 1*time.Second)
+
+	if err := reg.Register("b", b); err != nil {
+		t.Error(err)
+	}
+	if err := reg.Register("a", a); err != nil {
+		t.Error(err)
+	}
+	if err := reg.Register("a", b); err != ErrDuplicateName {
+		t.Error(err)
+	}
+	if err := reg.Register("c", nil); err != ErrNilBreaker {
+		t.Error(err)
+	}
+
+	if reg.Get("a") != a || reg.Get("b") != b || reg.Get("c") != nil {
+		t.Error("incorrect lookup")
+	}
+	if names := reg.Names(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Error(names)
+	}
+
+	reg.Unregister("a")
+	if reg.Get("a") != nil {
+		t.Error("a still registered")
+	}
+	if names := reg.Names(); !reflect.DeepEqual(names, []string{"b"}) {
+		t.Error(names)
+	}
+}
+
+func TestRegistryResetAllTripAll(t *testing.T) {
+	reg := NewRegistry()
+	var breakers []*Breaker
+	for _, name := range []string{"a", "b", "c"} {
+		b := New(1, 1, 1*time.Hour)
+		breakers = append(breakers, b)
+		if err := reg.Register(name, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reg.TripAll()
+	for i, b := range breakers {
+		if b.State() != Open {
+			t.Error(i, b.State())
+		}
+	}
+
+	reg.ResetAll()
+	for i, b := range breakers {
+		if b.State() != Closed {
+			t.Error(i, b.State())
+		}
+	}
+}
+
+func TestRegistryHealth(t *testing.T) {
+	reg := NewRegistry()
+	a := New(1, 1, 1*time.Hour)
+	b := New(1, 1, 1*time.Hour)
+	c := NewWithOptions(1, 1, 1*time.Hour, WithHalfOpenHealthy())
+	_ = reg.Register("a", a)
+	_ = reg.Register("b", b)
+	_ = reg.Register("c", c)
+
+	if !reg.AllHealthy() || reg.Unhealthy() != nil {
+		t.Error("unhealthy", reg.Unhealthy())
+	}
+
+	b.Trip()
+	a.HalfOpen()
+	c.HalfOpen()
+	if reg.AllHealthy() {
+		t.Error("all healthy")
+	}
+	if names := reg.Unhealthy(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Error(names)
+	}
+}
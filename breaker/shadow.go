@@ -0,0 +1,35 @@
+package breaker
+
+import "sync/atomic"
+
+// WithShadowMode starts the breaker in shadow mode (see SetShadowMode).
+func WithShadowMode() Option {
+	return func(b *Breaker) {
+		b.shadow = 1
+	}
+}
+
+// SetShadowMode turns shadow mode on or off. In shadow mode the breaker follows its
+// usual rules, opening, half-opening and closing as the results of calls dictate,
+// but never rejects a call: every call is run, whatever the state. Calls that would
+// have been rejected are reported as EventRejected with Shadow set, but their
+// results are not counted, since in earnest they would not have been made: an open
+// breaker in shadow mode therefore only half-opens through its recovery timer (or
+// HalfOpen or Reset), exactly as it would in earnest, however well those calls go.
+// Stats reports WouldBeOpen while the breaker is open, and counts the calls that
+// would have been shed in ShadowShed rather than Shed, and each trip is an
+// EventStateChange with Shadow set. This allows the thresholds for a breaker to be
+// tuned against real traffic before it is allowed to affect it. Turning shadow mode
+// off makes the breaker enforce its current state immediately. It is safe to call
+// concurrently with everything else.
+func (b *Breaker) SetShadowMode(on bool) {
+	var shadow uint32
+	if on {
+		shadow = 1
+	}
+	atomic.StoreUint32(&b.shadow, shadow)
+}
+
+func (b *Breaker) shadowed() bool {
+	return atomic.LoadUint32(&b.shadow) != 0
+}
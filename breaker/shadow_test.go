@@ -0,0 +1,88 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next buffered event, failing the test if there is none.
+// Events are delivered before the call that caused them returns, so there is no
+// need to wait.
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	default:
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+func TestBreakerShadowMode(t *testing.T) {
+	breaker := NewWithOptions(2, 1, 1*time.Hour, WithShadowMode(), WithHalfOpenProbes(1))
+	events := breaker.Events()
+	defer breaker.Unsubscribe(events)
+
+	// it trips as usual...
+	_ = breaker.Run(returnsError)
+	_ = breaker.Run(returnsError)
+	if stats := breaker.Stats(); stats.State != Open || !stats.WouldBeOpen || stats.AdmitRatio != 1 {
+		t.Fatalf("%+v", stats)
+	}
+	if event := nextEvent(t, events); event.To != Open || !event.Shadow {
+		t.Errorf("%+v", event)
+	}
+
+	// ...but still runs every call, without counting those it would have rejected
+	ran := false
+	if err := breaker.Run(func() error { ran = true; return nil }); err != nil || !ran {
+		t.Error(err, ran)
+	}
+	if event := nextEvent(t, events); event.Kind != EventRejected || !event.Shadow || event.Err != ErrBreakerOpen {
+		t.Errorf("%+v", event)
+	}
+	breaker.fireRecovery()
+	nextEvent(t, events)
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed")
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	nextEvent(t, events)
+	if breaker.State() != HalfOpen {
+		t.Error("a call past the probe limit was counted")
+	}
+
+	// turning it off enforces the current state
+	breaker.MarkProbeResult(errSomeError)
+	nextEvent(t, events)
+	breaker.SetShadowMode(false)
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if event := nextEvent(t, events); event.Kind != EventRejected || event.Shadow {
+		t.Errorf("%+v", event)
+	}
+	if stats := breaker.Stats(); stats.State != Open || stats.WouldBeOpen || stats.AdmitRatio != 0 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerShadowModeShed(t *testing.T) {
+	breaker := NewWithOptions(3, 1, 1*time.Hour, WithShadowMode(), WithDegradedState(1, 1))
+
+	// calls it would have shed are run, and counted apart from real sheds
+	_ = breaker.Run(returnsError)
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	breaker.SetShadowMode(false)
+	if err := breaker.Run(returnsSuccess); err != ErrLoadShed {
+		t.Error(err)
+	}
+	if stats := breaker.Stats(); stats.State != Degraded || stats.Shed != 1 || stats.ShadowShed != 1 {
+		t.Errorf("%+v", stats)
+	}
+}
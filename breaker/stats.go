@@ -0,0 +1,285 @@
+package breaker
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// State is the state of a Breaker.
+type State uint32
+
+// The states a Breaker can be in.
+const (
+	Closed   = State(closed)
+	Open     = State(open)
+	HalfOpen = State(halfOpen)
+	Pending  = State(pending)  // see WithStartupGate
+	Degraded = State(degraded) // see WithDegradedState
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	case Pending:
+		return "pending"
+	case Degraded:
+		return "degraded"
+	}
+	return "unknown"
+}
+
+// Cause says why a Breaker last changed state.
+type Cause uint8
+
+// The reasons a Breaker changes state.
+const (
+	CauseNone           Cause = iota // the breaker has not changed state since it was created
+	CauseThreshold                   // breaker opened or degraded by errors (or by exhausting its error budget)
+	CauseTimer                       // open breaker half-opened by its recovery timer
+	CauseProbeSuccess                // half-open or pending breaker closed by successful calls
+	CauseProbeFailure                // half-open breaker re-opened by a failed probe
+	CauseManual                      // Reset, Trip or HalfOpen was called
+	CauseErrorsSubsided              // degraded breaker closed once its errors fell back
+)
+
+func (c Cause) String() string {
+	switch c {
+	case CauseNone:
+		return "none"
+	case CauseThreshold:
+		return "threshold"
+	case CauseTimer:
+		return "timer"
+	case CauseProbeSuccess:
+		return "probe success"
+	case CauseProbeFailure:
+		return "probe failure"
+	case CauseManual:
+		return "manual"
+	case CauseErrorsSubsided:
+		return "errors subsided"
+	}
+	return "unknown"
+}
+
+// LatencyStats summarises how long a set of calls took to return.
+type LatencyStats struct {
+	Count int
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the average latency of the calls, or zero if there were none.
+func (l LatencyStats) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
+
+func (l *LatencyStats) add(d time.Duration) {
+	l.Count++
+	l.Total += d
+	if d > l.Max {
+		l.Max = d
+	}
+}
+
+// Stats is a snapshot of a Breaker's state and of the calls made through it.
+// Latencies are only recorded for calls made with RunContext.
+type Stats struct {
+	State        State
+	Errors       int           // recent errors counting towards opening a closed, degraded or pending breaker
+	Timeouts     int           // of those errors, how many were timeouts (see WithTimeoutThreshold)
+	Successes    float64       // successes counting towards closing a half-open or pending breaker
+	RecoversAt   time.Time     // when an open breaker will half-open; the zero Time otherwise
+	RecoversIn   time.Duration // how long until RecoversAt by the breaker's clock; zero if it is zero
+	ManualHold   bool          // open and waiting for HalfOpen or Reset (see WithManualRecovery)
+	WouldBeOpen  bool          // open, but in shadow mode so not rejecting calls (see SetShadowMode)
+	Budget       float64       // tokens left in the error budget, if configured with WithErrorBudget
+	Shed         int           // calls rejected with ErrLoadShed over the breaker's lifetime
+	ShadowShed   int           // calls that would have been shed, but were run in shadow mode
+	Ignored      int           // results classified as Ignore (or wrapped with IgnoreResult) over the breaker's lifetime
+	AdmitRatio   float64       // see Breaker.AdmitRatio
+	InFlight     int           // calls running through the breaker right now, bar any WithCancellationGrace gave up on
+	PeakInFlight int           // the most calls that have ever been running at once
+
+	LastCause      Cause     // why the breaker entered its current state
+	LastTransition time.Time // when it did so; the zero Time if it never changed state
+
+	// Calls admitted while half-open over the breaker's lifetime, how many of
+	// them succeeded and how many failed and so re-opened it. Probes whose result
+	// arrives after the breaker has already moved on (or that are ignored) are
+	// attempted but neither succeed nor re-open.
+	ProbesAttempted, ProbesSucceeded, ProbesReopened int
+
+	SuccessLatency  LatencyStats // calls classified as successes
+	FailureLatency  LatencyStats // calls classified as failures, including panics
+	CanceledLatency LatencyStats // calls that returned an error after their context was cancelled
+	TimeoutLatency  LatencyStats // calls that returned an error after their context's deadline, or classified as timeouts
+
+	DroppedEvents int // events not delivered because a subscriber's channel was full (see Events)
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.lazyInit()
+
+	b.catchUpRecovery()
+	return State(atomic.LoadUint32(&b.state))
+}
+
+// Healthy reports whether the breaker considers its dependency usable right now,
+// for example for a readiness check. A closed or degraded breaker is healthy and an
+// open one is not. A half-open or pending breaker has yet to prove its dependency,
+// so is only healthy if the breaker was built with WithHalfOpenHealthy.
+func (b *Breaker) Healthy() bool {
+	switch b.State() {
+	case Closed, Degraded:
+		return true
+	case HalfOpen, Pending:
+		return b.probing
+	}
+	return false
+}
+
+// Stats returns a snapshot of the breaker's state and statistics. It is safe to
+// call concurrently with Run, though the breaker may change at any moment after.
+func (b *Breaker) Stats() Stats {
+	b.lazyInit()
+
+	var s Stats
+
+	b.lock.Lock()
+	halfOpened := b.catchUp()
+	s.State = State(b.state)
+	s.Errors = b.errors
+	s.Timeouts = b.timeouts
+	s.Successes = b.successes
+	s.RecoversAt = b.recoversAt()
+	if !s.RecoversAt.IsZero() {
+		if s.RecoversIn = s.RecoversAt.Sub(b.clock.Now()); s.RecoversIn < 0 {
+			s.RecoversIn = 0
+		}
+	}
+	s.ManualHold = b.state == open && b.manual
+	s.WouldBeOpen = b.state == open && b.shadowed()
+	s.AdmitRatio = b.admitRatio()
+	s.Shed = b.shed
+	s.ShadowShed = b.shadowShed
+	s.LastCause = b.transition.cause
+	s.LastTransition = b.transition.at
+	if b.budget != nil {
+		s.Budget = b.budget.available(b.clock.Now())
+	}
+	s.ProbesAttempted = b.probeStats.attempted
+	s.ProbesSucceeded = b.probeStats.succeeded
+	s.ProbesReopened = b.probeStats.reopened
+	b.lock.Unlock()
+
+	if halfOpened {
+		b.halfOpened()
+	}
+
+	b.latency.Lock()
+	s.SuccessLatency = b.latency.success
+	s.FailureLatency = b.latency.failure
+	s.CanceledLatency = b.latency.canceled
+	s.TimeoutLatency = b.latency.timeout
+	b.latency.Unlock()
+
+	s.DroppedEvents = b.droppedEvents()
+	s.Ignored = int(atomic.LoadUint64(&b.counters.ignored))
+	s.InFlight = int(atomic.LoadInt64(&b.counters.inFlight))
+	s.PeakInFlight = int(atomic.LoadInt64(&b.counters.peak))
+
+	return s
+}
+
+// AdmitRatio returns the fraction of calls the breaker would admit right now: 1 when
+// closed (or pending), 0 when open, and one minus the shed fraction when degraded
+// (see WithDegradedState). A half-open breaker admits everything until it reaches
+// its probe limit (see WithHalfOpenProbes), at which point it admits nothing, so it
+// is 1 or 0 accordingly. In shadow mode (see SetShadowMode) every call is admitted,
+// so it is always 1.
+func (b *Breaker) AdmitRatio() float64 {
+	b.lazyInit()
+
+	b.lock.Lock()
+	halfOpened := b.catchUp()
+	ratio := b.admitRatio()
+	b.lock.Unlock()
+
+	if halfOpened {
+		b.halfOpened()
+	}
+	return ratio
+}
+
+func (b *Breaker) admitRatio() float64 {
+	if b.shadowed() {
+		return 1
+	}
+	switch b.state {
+	case closed, pending:
+		return 1
+	case halfOpen:
+		if b.probeAvailable() {
+			return 1
+		}
+	case degraded:
+		return math.Max(0, 1-b.shedFraction)
+	}
+	return 0
+}
+
+// Name returns the name given to the breaker with WithName, if any.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// String returns a short human-readable summary of the breaker, such as
+// `circuit breaker "db": open, 0 errors, 0 successes, half-opens in 4.5s`.
+func (b *Breaker) String() string {
+	b.lazyInit()
+
+	b.lock.Lock()
+	halfOpened := b.catchUp()
+	state := State(b.state)
+	errors, successes := b.errors, b.successes
+	var remaining time.Duration
+	if recovers := b.recoversAt(); !recovers.IsZero() {
+		remaining = recovers.Sub(b.clock.Now())
+	}
+	b.lock.Unlock()
+
+	if halfOpened {
+		b.halfOpened()
+	}
+
+	var buf strings.Builder
+	buf.WriteString("circuit breaker")
+	if b.name != "" {
+		fmt.Fprintf(&buf, " %q", b.name)
+	}
+	fmt.Fprintf(&buf, ": %s, %d errors, %g successes", state, errors, successes)
+	if state == Open {
+		if b.manual {
+			buf.WriteString(", held for manual recovery")
+		} else {
+			if remaining < 0 {
+				remaining = 0
+			}
+			fmt.Fprintf(&buf, ", half-opens in %v", remaining)
+		}
+	}
+	return buf.String()
+}
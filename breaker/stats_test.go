@@ -0,0 +1,282 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStateString(t *testing.T) {
+	for state, str := range map[State]string{
+		Closed:   "closed",
+		Open:     "open",
+		HalfOpen: "half-open",
+		Pending:  "pending",
+		Degraded: "degraded",
+		State(9): "unknown",
+	} {
+		if state.String() != str {
+			t.Errorf("%d printed as %q", state, state.String())
+		}
+	}
+}
+
+func TestLatencyStatsMean(t *testing.T) {
+	var l LatencyStats
+	if l.Mean() != 0 {
+		t.Error("empty mean should be zero")
+	}
+	l.add(10 * time.Millisecond)
+	l.add(30 * time.Millisecond)
+	if l.Mean() != 20*time.Millisecond || l.Max != 30*time.Millisecond {
+		t.Errorf("mean %v max %v", l.Mean(), l.Max)
+	}
+}
+
+func TestBreakerRunContextLatency(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(clock))
+
+	takes := func(d time.Duration, err error) func(context.Context) error {
+		return func(ctx context.Context) error {
+			clock.Advance(d)
+			if err == nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+
+	if err := breaker.RunContext(context.Background(), takes(10*time.Millisecond, nil)); err != nil {
+		t.Error(err)
+	}
+
+	// cancellation by the caller is recorded but doesn't trip the breaker
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := breaker.RunContext(canceled, takes(5*time.Millisecond, nil)); err != context.Canceled {
+		t.Error(err)
+	}
+	if breaker.State() != Closed {
+		t.Error("cancellation tripped the breaker")
+	}
+
+	// a timeout does
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-1*time.Second))
+	defer cancel()
+	if err := breaker.RunContext(expired, takes(20*time.Millisecond, nil)); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if breaker.State() != Open {
+		t.Error("timeout did not trip the breaker")
+	}
+
+	clock.Advance(1 * time.Second)
+	if err := breaker.RunContext(context.Background(), takes(40*time.Millisecond, errSomeError)); err != errSomeError {
+		t.Error(err)
+	}
+
+	stats := breaker.Stats()
+	if stats.State != Open {
+		t.Error("stats state", stats.State)
+	}
+	for name, latency := range map[string]struct {
+		got  LatencyStats
+		want time.Duration
+	}{
+		"success":  {stats.SuccessLatency, 10 * time.Millisecond},
+		"canceled": {stats.CanceledLatency, 5 * time.Millisecond},
+		"timeout":  {stats.TimeoutLatency, 20 * time.Millisecond},
+		"failure":  {stats.FailureLatency, 40 * time.Millisecond},
+	} {
+		if latency.got.Count != 1 || latency.got.Total != latency.want {
+			t.Errorf("%s latency %+v, expected one call of %v", name, latency.got, latency.want)
+		}
+	}
+}
+
+func TestBreakerString(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(3, 2, 5*time.Second, WithClock(clock), WithName("db"))
+
+	if breaker.Name() != "db" {
+		t.Error(breaker.Name())
+	}
+
+	_ = breaker.Run(returnsError)
+	if s := breaker.String(); s != `circuit breaker "db": closed, 1 errors, 0 successes` {
+		t.Error(s)
+	}
+
+	breaker.Trip()
+	clock.Advance(500 * time.Millisecond)
+	if s := breaker.String(); s != `circuit breaker "db": open, 0 errors, 0 successes, half-opens in 4.5s` {
+		t.Error(s)
+	}
+
+	clock.Advance(5 * time.Second)
+	_ = breaker.Run(returnsSuccess)
+	if s := breaker.String(); s != `circuit breaker "db": half-open, 0 errors, 1 successes` {
+		t.Error(s)
+	}
+
+	if s := New(1, 1, 1*time.Second).String(); s != "circuit breaker: closed, 0 errors, 0 successes" {
+		t.Error(s)
+	}
+}
+
+func TestBreakerProbeStats(t *testing.T) {
+	breaker := New(1, 2, 1*time.Hour)
+
+	// closed and open calls aren't probes
+	_ = breaker.Run(returnsSuccess)
+	_ = breaker.Run(returnsError)
+	_ = breaker.Run(returnsSuccess)
+
+	// a probe that succeeds then one that re-opens
+	breaker.fireRecovery()
+	_ = breaker.Run(returnsSuccess)
+	_ = breaker.Run(returnsError)
+
+	// two that succeed and close it, plus one external probe before them
+	breaker.fireRecovery()
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed")
+	}
+	_ = breaker.Run(returnsSuccess)
+	_ = breaker.Run(returnsSuccess)
+	breaker.MarkProbeResult(errSomeError) // too late; already closed
+
+	stats := breaker.Stats()
+	if stats.ProbesAttempted != 5 || stats.ProbesSucceeded != 3 || stats.ProbesReopened != 1 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+func TestBreakerLastCause(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Second, WithClock(clock))
+
+	expect := func(cause Cause) {
+		t.Helper()
+		stats := breaker.Stats()
+		if stats.LastCause != cause || !stats.LastTransition.Equal(clock.Now()) {
+			t.Errorf("want %v at %v, got %+v", cause, clock.Now(), stats)
+		}
+	}
+
+	if stats := breaker.Stats(); stats.LastCause != CauseNone || !stats.LastTransition.IsZero() {
+		t.Errorf("%+v", stats)
+	}
+
+	_ = breaker.Run(returnsError)
+	expect(CauseThreshold)
+
+	clock.Advance(1 * time.Second)
+	expect(CauseTimer)
+
+	_ = breaker.Run(returnsError)
+	expect(CauseProbeFailure)
+
+	breaker.HalfOpen()
+	expect(CauseManual)
+
+	_ = breaker.Run(returnsSuccess)
+	expect(CauseProbeSuccess)
+
+	breaker.Trip()
+	expect(CauseManual)
+
+	clock.Advance(1 * time.Minute)
+	breaker.Reset()
+	expect(CauseManual)
+
+	if CauseProbeSuccess.String() != "probe success" || Cause(99).String() != "unknown" {
+		t.Error("bad cause strings")
+	}
+}
+
+func TestBreakerHealthy(t *testing.T) {
+	for _, test := range []struct {
+		state         State
+		strict, loose bool
+	}{
+		{Closed, true, true},
+		{Degraded, true, true},
+		{HalfOpen, false, true},
+		{Pending, false, true},
+		{Open, false, false},
+	} {
+		strict := New(1, 1, 1*time.Hour)
+		loose := NewWithOptions(1, 1, 1*time.Hour, WithHalfOpenHealthy())
+		for _, b := range []*Breaker{strict, loose} {
+			b.state = uint32(test.state)
+		}
+		if strict.Healthy() != test.strict || loose.Healthy() != test.loose {
+			t.Error(test.state, strict.Healthy(), loose.Healthy())
+		}
+	}
+}
+
+func TestBreakerAdmitRatio(t *testing.T) {
+	breaker := NewWithOptions(2, 2, 1*time.Hour, WithHalfOpenProbes(1), WithDegradedState(1, 0.25))
+
+	if ratio := breaker.AdmitRatio(); ratio != 1 {
+		t.Error(ratio)
+	}
+	_ = breaker.Run(returnsError)
+	if stats := breaker.Stats(); stats.State != Degraded || stats.AdmitRatio != 0.75 {
+		t.Errorf("%+v", stats)
+	}
+	breaker.Trip()
+	if ratio := breaker.AdmitRatio(); ratio != 0 {
+		t.Error(ratio)
+	}
+
+	// half-open breakers admit until their probe limit is reached
+	breaker.HalfOpen()
+	if ratio := breaker.AdmitRatio(); ratio != 1 {
+		t.Error(ratio)
+	}
+	if !breaker.AllowProbe() {
+		t.Fatal("probe not allowed")
+	}
+	if ratio := breaker.AdmitRatio(); ratio != 0 {
+		t.Error(ratio)
+	}
+}
+
+func TestBreakerInFlight(t *testing.T) {
+	breaker := New(1, 1, 1*time.Hour)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		_ = breaker.Go(func() error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+	}
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+
+	err := breaker.RunContext(context.Background(), func(ctx context.Context) error {
+		if stats := breaker.Stats(); stats.InFlight != 4 || stats.PeakInFlight != 4 {
+			t.Errorf("%+v", stats)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	close(release)
+	for breaker.Stats().InFlight != 0 {
+		time.Sleep(1 * time.Millisecond)
+	}
+	if stats := breaker.Stats(); stats.PeakInFlight != 4 {
+		t.Errorf("%+v", stats)
+	}
+}
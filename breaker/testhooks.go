@@ -0,0 +1,59 @@
+//go:build breakertest
+// +build breakertest
+
+package breaker
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// SetStateForTest puts the breaker straight into the given state with the given
+// counters, for tests of code that reacts to a breaker's state, that would otherwise
+// have to drive it there with a series of calls. It is only built with the
+// "breakertest" build tag (go test -tags breakertest), and is not for use outside of
+// tests: it skips the callbacks (WithOnHalfOpen, WithOnClose) and the checks the
+// normal transitions make, though a change of state is still delivered by Events and
+// kept in History, with CauseManual.
+//
+// As with a real transition, on a change of state any call in flight is discarded
+// when it completes. Setting the state the breaker is already in is not a
+// transition, so only replaces the counters: there is no event, and calls in flight
+// still count. Either way an open breaker is scheduled to half-open the usual
+// timeout from now, so setting Open on an open breaker restarts its timer (unless it
+// was built with WithManualRecovery). A breaker put in the degraded state without
+// having been built with WithDegradedState sheds nothing. Errors count as if they
+// had all just been seen. In half-open, successes count as though probes had made
+// them. It takes the breaker's lock, so is safe to use while other goroutines use
+// the breaker.
+func (b *Breaker) SetStateForTest(s State, errors, successes int) {
+	b.lazyInit()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch s {
+	case Degraded:
+		if b.rand == nil {
+			b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		b.stopRecovery()
+		b.changeState(degraded, CauseManual)
+	case Closed, HalfOpen, Pending:
+		b.stopRecovery()
+		b.changeState(uint32(s), CauseManual)
+	case Open:
+		b.openBreaker(CauseManual)
+	default:
+		panic(fmt.Sprintf("breaker: SetStateForTest with invalid state %d", s))
+	}
+
+	b.errors = errors
+	b.timeouts = 0
+	b.lastError = time.Time{}
+	if errors > 0 {
+		b.lastError = b.clock.Now()
+	}
+	b.successes = float64(successes)
+}
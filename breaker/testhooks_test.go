@@ -0,0 +1,65 @@
+//go:build breakertest
+// +build breakertest
+
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerSetStateForTest(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(3, 3, 1*time.Second, WithClock(clock))
+
+	breaker.SetStateForTest(Closed, 2, 0)
+	if err := breaker.Run(returnsError); err != errSomeError || breaker.State() != Open {
+		t.Fatal(err, breaker.State())
+	}
+
+	breaker.SetStateForTest(HalfOpen, 0, 2)
+	if clock.Pending() != 0 {
+		t.Error("recovery still scheduled")
+	}
+	_ = breaker.Run(returnsSuccess)
+	if breaker.State() != Closed {
+		t.Fatal(breaker.State())
+	}
+
+	breaker.SetStateForTest(Open, 0, 0)
+	clock.Advance(1 * time.Second)
+	if breaker.State() != HalfOpen {
+		t.Error(breaker.State())
+	}
+
+	// the same state again only replaces the counters, and restarts the timer
+	events := breaker.Events()
+	defer breaker.Unsubscribe(events)
+	breaker.SetStateForTest(Open, 0, 0)
+	clock.Advance(500 * time.Millisecond)
+	breaker.SetStateForTest(Open, 1, 0)
+	clock.Advance(500 * time.Millisecond)
+	if stats := breaker.Stats(); stats.State != Open || stats.Errors != 1 {
+		t.Errorf("%+v", stats)
+	}
+	if len(events) != 1 {
+		t.Error(len(events), "events")
+	}
+	clock.Advance(500 * time.Millisecond)
+	if breaker.State() != HalfOpen {
+		t.Error(breaker.State())
+	}
+
+	// degraded works without WithDegradedState, shedding nothing
+	breaker.SetStateForTest(Degraded, 1, 0)
+	if err := breaker.Run(returnsSuccess); err != nil || breaker.State() != Degraded {
+		t.Error(err, breaker.State())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for an invalid state")
+		}
+	}()
+	breaker.SetStateForTest(State(99), 0, 0)
+}
@@ -0,0 +1,170 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInsufficientDeadline is the error returned from RunContext when the function
+// is not executed because the context's deadline is too close for the call to
+// plausibly succeed (see WithDeadlineShedding).
+var ErrInsufficientDeadline = errors.New("not enough time left before the deadline to make the call")
+
+// WithDeadlineShedding makes a half-open breaker's RunContext reject calls whose
+// context does not leave them enough time to succeed, with ErrInsufficientDeadline,
+// instead of spending its probe slots on calls that are likely to time out and
+// re-open it. The time needed is the 99th percentile latency of recent successful
+// calls, but never less than "minimum". Rejected calls do not affect the breaker.
+// Contexts without a deadline are never rejected, and nor is any call while the
+// breaker is in another state, where a doomed call costs no more than its timeout.
+//
+// When combined with WithAdaptiveTimeout, note that a call is admitted if it has
+// time for the 99th percentile latency, which may be less than the adaptive
+// timeout (the percentile scaled by its multiplier); such calls run with the
+// context's own, earlier, deadline.
+func WithDeadlineShedding(minimum time.Duration) Option {
+	return func(b *Breaker) {
+		b.minDeadline = minimum
+	}
+}
+
+func (b *Breaker) checkDeadline(ctx context.Context) error {
+	if b.minDeadline <= 0 || atomic.LoadUint32(&b.state) != halfOpen {
+		return nil
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+
+	needed := b.minDeadline
+	b.latency.Lock()
+	if p99, ok := b.latency.recent.quantile(0.99); ok && p99 > needed {
+		needed = p99
+	}
+	b.latency.Unlock()
+
+	if deadline.Sub(b.clock.Now()) < needed {
+		return ErrInsufficientDeadline
+	}
+	return nil
+}
+
+// WithCallTimeout gives each call made with RunContext a fixed timeout, by passing
+// the function a child context created with context.WithTimeout. This only has any
+// effect if the function honours context cancellation, but for one that does it
+// means a hung call is cancelled rather than left running. An error returned after
+// the timeout expires counts as a timeout, and so as a failure. WithAdaptiveTimeout
+// takes precedence over this option if both are used.
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(b *Breaker) {
+		b.fixedTimeout = timeout
+	}
+}
+
+// WithAdaptiveTimeout gives each call made with RunContext a timeout that follows
+// how long the dependency has recently been taking: "multiplier" times the 99th
+// percentile latency of recent successful calls, kept between "floor" and "ceiling".
+// Until there are any successful calls to go by, the ceiling is used. The timeout
+// is applied by passing the function a child context created with
+// context.WithTimeout, so it only has any effect if the function honours context
+// cancellation; an error returned after it expires counts as a timeout.
+//
+// A ceiling of zero or less means DefaultTimeout, a multiplier of zero or less
+// means 1, and a floor above the ceiling is lowered to it.
+func WithAdaptiveTimeout(multiplier float64, floor, ceiling time.Duration) Option {
+	ceiling = validTimeout(ceiling)
+	if !(multiplier > 0) {
+		multiplier = 1
+	}
+	if floor > ceiling {
+		floor = ceiling
+	}
+	return func(b *Breaker) {
+		b.adaptive = &adaptiveTimeout{
+			multiplier: multiplier,
+			floor:      floor,
+			ceiling:    ceiling,
+		}
+	}
+}
+
+type adaptiveTimeout struct {
+	multiplier     float64
+	floor, ceiling time.Duration
+}
+
+// callTimeout returns the timeout to apply to the next call made with RunContext,
+// if there is one.
+func (b *Breaker) callTimeout() (time.Duration, bool) {
+	if b.adaptive == nil {
+		return b.fixedTimeout, b.fixedTimeout > 0
+	}
+
+	b.latency.Lock()
+	p99, ok := b.latency.recent.quantile(0.99)
+	b.latency.Unlock()
+
+	if !ok {
+		return b.adaptive.ceiling, true
+	}
+
+	timeout := time.Duration(float64(p99) * b.adaptive.multiplier)
+	if timeout < b.adaptive.floor {
+		timeout = b.adaptive.floor
+	}
+	if timeout > b.adaptive.ceiling {
+		timeout = b.adaptive.ceiling
+	}
+	return timeout, true
+}
+
+// latencySamples keeps the most recent call latencies in a ring, along with a
+// lazily-computed sorted copy for calculating quantiles.
+type latencySamples struct {
+	ring   [128]time.Duration
+	next   int
+	full   bool
+	sorted []time.Duration // nil when out of date
+}
+
+func (l *latencySamples) add(d time.Duration) {
+	l.ring[l.next] = d
+	l.next++
+	if l.next == len(l.ring) {
+		l.next = 0
+		l.full = true
+	}
+	l.sorted = nil
+}
+
+// quantile returns the q'th quantile (0 < q <= 1) of the samples, or false if
+// there are none.
+func (l *latencySamples) quantile(q float64) (time.Duration, bool) {
+	n := l.next
+	if l.full {
+		n = len(l.ring)
+	}
+	if n == 0 {
+		return 0, false
+	}
+
+	if l.sorted == nil {
+		l.sorted = append(make([]time.Duration, 0, n), l.ring[:n]...)
+		sort.Slice(l.sorted, func(i, j int) bool { return l.sorted[i] < l.sorted[j] })
+	}
+
+	// nearest-rank method
+	i := int(math.Ceil(q*float64(n))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= n {
+		i = n - 1
+	}
+	return l.sorted[i], true
+}
@@ -0,0 +1,221 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatencySamplesQuantile(t *testing.T) {
+	var l latencySamples
+	if _, ok := l.quantile(0.99); ok {
+		t.Error("quantile of no samples")
+	}
+
+	for i := 1; i <= 100; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	if p99, _ := l.quantile(0.99); p99 != 99*time.Millisecond {
+		t.Error(p99)
+	}
+	if p50, _ := l.quantile(0.5); p50 != 50*time.Millisecond {
+		t.Error(p50)
+	}
+
+	// only the most recent samples are kept
+	for i := 0; i < len(l.ring); i++ {
+		l.add(1 * time.Millisecond)
+	}
+	if p99, _ := l.quantile(0.99); p99 != 1*time.Millisecond {
+		t.Error(p99)
+	}
+}
+
+func TestBreakerAdaptiveTimeout(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(100, 1, 1*time.Second, WithClock(clock),
+		WithAdaptiveTimeout(1.5, 10*time.Millisecond, 1*time.Second))
+
+	takes := func(d time.Duration) func(context.Context) error {
+		return func(context.Context) error {
+			clock.Advance(d)
+			return nil
+		}
+	}
+
+	// cold start uses the ceiling
+	if timeout, ok := breaker.callTimeout(); !ok || timeout != 1*time.Second {
+		t.Error(timeout, ok)
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := breaker.RunContext(context.Background(), takes(100*time.Millisecond)); err != nil {
+			t.Error(err)
+		}
+	}
+	if timeout, _ := breaker.callTimeout(); timeout != 150*time.Millisecond {
+		t.Error(timeout)
+	}
+
+	// failures don't count towards the latency
+	for i := 0; i < 100; i++ {
+		_ = breaker.RunContext(context.Background(), func(context.Context) error {
+			clock.Advance(10 * time.Second)
+			return errSomeError
+		})
+	}
+	if timeout, _ := breaker.callTimeout(); timeout != 150*time.Millisecond {
+		t.Error(timeout)
+	}
+
+	// clamped to the floor and ceiling
+	for i := 0; i < 128; i++ {
+		_ = breaker.RunContext(context.Background(), takes(1*time.Millisecond))
+	}
+	if timeout, _ := breaker.callTimeout(); timeout != 10*time.Millisecond {
+		t.Error(timeout)
+	}
+	for i := 0; i < 128; i++ {
+		_ = breaker.RunContext(context.Background(), takes(1*time.Second))
+	}
+	if timeout, _ := breaker.callTimeout(); timeout != 1*time.Second {
+		t.Error(timeout)
+	}
+}
+
+func TestBreakerCallTimeout(t *testing.T) {
+	breaker := NewWithOptions(2, 1, 1*time.Hour, WithCallTimeout(10*time.Millisecond))
+
+	// a well-behaved function is cancelled at the timeout...
+	start := time.Now()
+	err := breaker.RunContext(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > 1*time.Second {
+		t.Error("not cancelled in time", elapsed)
+	}
+
+	// ...and that counts as a failure
+	if stats := breaker.Stats(); stats.Errors != 1 || stats.TimeoutLatency.Count != 1 {
+		t.Errorf("%+v", stats)
+	}
+
+	// an earlier deadline from the caller still applies
+	parent, cancel := context.WithTimeout(context.Background(), 1*time.Millisecond)
+	defer cancel()
+	want, _ := parent.Deadline()
+	_ = breaker.RunContext(parent, func(ctx context.Context) error {
+		if deadline, _ := ctx.Deadline(); !deadline.Equal(want) {
+			t.Error("parent deadline not kept", deadline, want)
+		}
+		return nil
+	})
+
+	// nonsensical settings still give a usable timeout
+	for _, breaker := range []*Breaker{
+		NewWithOptions(1, 1, 1*time.Hour, WithAdaptiveTimeout(1, 0, 0)),
+		NewWithOptions(1, 1, 1*time.Hour, WithAdaptiveTimeout(0, 0, DefaultTimeout)),
+		NewWithOptions(1, 1, 1*time.Hour, WithAdaptiveTimeout(1, 2*DefaultTimeout, DefaultTimeout)),
+	} {
+		breaker.latency.recent.add(1 * time.Second)
+		if timeout, ok := breaker.callTimeout(); !ok || timeout <= 0 || timeout > DefaultTimeout {
+			t.Error(timeout, ok)
+		}
+	}
+
+	// the adaptive timeout takes precedence
+	both := NewWithOptions(1, 1, 1*time.Hour, WithCallTimeout(1*time.Millisecond),
+		WithAdaptiveTimeout(1, 1*time.Second, 2*time.Second))
+	if timeout, _ := both.callTimeout(); timeout != 2*time.Second {
+		t.Error(timeout)
+	}
+}
+
+func TestBreakerAdaptiveTimeoutExpires(t *testing.T) {
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithAdaptiveTimeout(2, 1*time.Millisecond, 20*time.Millisecond))
+
+	err := breaker.RunContext(context.Background(), func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		if !ok || time.Until(deadline) > 20*time.Millisecond {
+			t.Error("no deadline within the ceiling", deadline, ok)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	stats := breaker.Stats()
+	if stats.State != Open || stats.TimeoutLatency.Count != 1 {
+		t.Errorf("%+v", stats)
+	}
+}
+
+// clockDeadline is a context whose deadline is on a testClock, so that it is
+// never actually done.
+type clockDeadline struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c clockDeadline) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func TestBreakerDeadlineShedding(t *testing.T) {
+	clock := newTestClock()
+	breaker := NewWithOptions(1, 1, 1*time.Hour, WithClock(clock), WithHalfOpenProbes(1),
+		WithDeadlineShedding(50*time.Millisecond))
+
+	ran := false
+	work := func(context.Context) error {
+		ran = true
+		return nil
+	}
+	withDeadline := func(d time.Duration) context.Context {
+		return clockDeadline{context.Background(), clock.Now().Add(d)}
+	}
+
+	// while closed even a call with too little time is admitted
+	if err := breaker.RunContext(withDeadline(10*time.Millisecond), work); err != nil || !ran {
+		t.Error(err, ran)
+	}
+	if err := breaker.RunContext(context.Background(), func(context.Context) error {
+		clock.Advance(1 * time.Second)
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	// while half-open too little time for the minimum is rejected without running
+	breaker.Trip()
+	breaker.fireRecovery()
+	ran = false
+	executed, err := breaker.RunContextExecuted(withDeadline(10*time.Millisecond), work)
+	if err != ErrInsufficientDeadline || executed || ran {
+		t.Error(err, executed, ran)
+	}
+
+	// observed latency raises the bar above the minimum
+	if err := breaker.RunContext(withDeadline(500*time.Millisecond), work); err != ErrInsufficientDeadline {
+		t.Error(err)
+	}
+
+	// the rejected calls didn't take the only probe slot, and no deadline, or
+	// plenty of time, is fine
+	if err := breaker.RunContext(context.Background(), work); err != nil || !ran {
+		t.Error(err, ran)
+	}
+	breaker.Trip()
+	breaker.fireRecovery()
+	ran = false
+	if err := breaker.RunContext(withDeadline(1*time.Hour), work); err != nil || !ran {
+		t.Error(err, ran)
+	}
+	if breaker.State() != Closed {
+		t.Error(breaker.State())
+	}
+}